@@ -0,0 +1,41 @@
+package discovery
+
+import (
+	"github.com/RealFax/red-discovery/internal/limiter"
+)
+
+type AdaptiveLimitConfig = limiter.AIMDConfig
+
+var (
+	DefaultAdaptiveLimitConfig = AdaptiveLimitConfig{
+		InitialLimit:  20,
+		MinLimit:      1,
+		MaxLimit:      1000,
+		Tolerance:     2,
+		BackoffRatio:  0.9,
+		ProbeInterval: 1000,
+	}
+)
+
+// WithAdaptiveLimit enable the adaptive concurrency limit of every endpoint in the service.
+//
+// The limit of an endpoint is learned from the round trip time of all calls made on its connections,
+// including the connections returned by NextAliveConn, an endpoint which reaches its limit is skipped
+// by the load balancing until some of its calls finish.
+func WithAdaptiveLimit(cfg AdaptiveLimitConfig) ServiceOption {
+	return func(s *service) {
+		s.adaptiveCfg.Store(&cfg)
+		s.endpoints.Range(func(id string, _ *Endpoint) bool {
+			s.adaptive.Store(id, limiter.NewAIMD(cfg))
+			return true
+		})
+	}
+}
+
+func (s *service) newAdaptiveLimiter() *limiter.AIMD {
+	cfg := s.adaptiveCfg.Load()
+	if cfg == nil {
+		return nil
+	}
+	return limiter.NewAIMD(*cfg)
+}
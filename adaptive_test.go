@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"testing"
+	"time"
+)
+
+func TestService_AdaptiveLimitStreams(t *testing.T) {
+	srv := newTestService(
+		t,
+		[]*testServer{newTestServer(t, drain)},
+		WithAdaptiveLimit(AdaptiveLimitConfig{InitialLimit: 1, MaxLimit: 1}),
+	)
+	a, _ := srv.adaptive.Load(testEndpointID(0))
+
+	stream, err := srv.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true}, testMethod,
+		WithInvokeTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stream is in flight until it is finished
+	if inflight := a.Inflight(); inflight != 1 {
+		t.Fatalf("unexpected inflight of an open stream: %d", inflight)
+	}
+	if _, err = srv.NewStream(context.Background(), &grpc.StreamDesc{ClientStreams: true}, testMethod); err == nil {
+		t.Fatal("the open stream didn't hold the adaptive limit")
+	}
+
+	if err = stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.RecvMsg(&emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if inflight := a.Inflight(); inflight != 0 {
+		t.Fatalf("unexpected inflight of a finished stream: %d", inflight)
+	}
+	if stats, _ := srv.Stats(testEndpointID(0)); stats.Requests != 1 || stats.Failures != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestService_StreamFailureReported(t *testing.T) {
+	srv := newTestService(t, []*testServer{newTestServer(t, fail(codes.Unavailable))})
+
+	stream, err := srv.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, testMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.RecvMsg(&emptypb.Empty{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats, _ := srv.Stats(testEndpointID(0)); stats.Requests != 1 || stats.Failures != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
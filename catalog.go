@@ -0,0 +1,165 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"github.com/RealFax/RedQueen/client"
+	"github.com/RealFax/red-discovery/internal/hack"
+	jsoniter "github.com/json-iterator/go"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ServiceSummary describes a Naming registered in the namespace.
+type ServiceSummary struct {
+	Naming string
+
+	// Endpoints is the number of registered endpoints.
+	Endpoints int
+
+	// Versions counts the endpoints of each version, endpoints without version are counted by "".
+	Versions map[string]int
+
+	// Metadata counts the endpoints publishing each top level metadata key.
+	Metadata map[string]int
+}
+
+// scanPrefix scans all the values with prefix page by page, see SetScanPageSize.
+//
+// RedQueen doesn't return the keys of a scan, the offset of the next page is the cursor.
+// A short page isn't regarded as the last one, since the server may cap the limit,
+// the scan ends with an empty page. It returns the number of scanned values.
+//
+// The pages aren't a consistent snapshot, the keys written or deleted during the scan shift the offsets,
+// a value may be scanned twice or skipped.
+func scanPrefix(ctx context.Context, kv client.KvClient, prefix string, f func(value *client.Value) bool) (int, error) {
+	var (
+		offset   uint64
+		pageSize = ScanPageSize()
+	)
+	for {
+		values, err := kv.PrefixScan(ctx, hack.String2Bytes(prefix), offset, pageSize, nil, namespace.Load())
+		if err != nil {
+			return int(offset), err
+		}
+		if len(values) == 0 {
+			return int(offset), nil
+		}
+		for i, value := range values {
+			if !f(value) {
+				return int(offset) + i + 1, nil
+			}
+		}
+		offset += uint64(len(values))
+	}
+}
+
+// scannedEndpoint parses an endpoint returned by a scan, and resolves its naming
+// from the recorded Naming or the key.
+func scannedEndpoint(value *client.Value) (*Endpoint, bool) {
+	endpoint, err := ParseEndpoint(value.Data)
+	if err != nil || endpoint.ID == "" {
+		return nil, false
+	}
+	// RedQueen v0.7 returns the value in place of the key
+	if endpoint.Naming == "" && !bytes.Equal(value.Key, value.Data) {
+		if naming, _, err := ParseEndpointPath(hack.Bytes2String(value.Key)); err == nil {
+			endpoint.Naming = naming
+		}
+	}
+	endpoint.SetTTL(value.TTL)
+	endpoint.touch(time.Now().UnixMilli())
+	return endpoint, true
+}
+
+// ListServices scans the namespace and returns the registered namings sorted by Naming,
+// endpoints registered without a recorded Naming are not listed.
+//
+// The result isn't a consistent snapshot, an endpoint registered or removed during the scan
+// may shift the pages and hide another endpoint, an endpoint scanned twice is counted once.
+func (c *Client) ListServices(ctx context.Context) ([]ServiceSummary, error) {
+	var (
+		summaries = make(map[string]*ServiceSummary)
+		// the endpoints are stored twice with KeyLayoutDual, or scanned twice once the pages shift
+		seen = make(map[string]struct{})
+	)
+	if _, err := scanPrefix(ctx, c.client, "", func(value *client.Value) bool {
+		endpoint, ok := scannedEndpoint(value)
+		if !ok || endpoint.Naming == "" {
+			return true
+		}
+		key := EndpointKey(endpoint.Naming, endpoint.ID)
+		if _, ok = seen[key]; ok {
+			return true
+		}
+		seen[key] = struct{}{}
+
+		summary, ok := summaries[endpoint.Naming]
+		if !ok {
+			summary = &ServiceSummary{
+				Naming:   endpoint.Naming,
+				Versions: make(map[string]int),
+				Metadata: make(map[string]int),
+			}
+			summaries[endpoint.Naming] = summary
+		}
+		summary.Endpoints++
+		summary.Versions[endpoint.Version]++
+
+		var md map[string]jsoniter.RawMessage
+		if jsoniter.ConfigFastest.Unmarshal(endpoint.Metadata, &md) == nil {
+			for key := range md {
+				summary.Metadata[key]++
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	list := make([]ServiceSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		list = append(list, *summary)
+	}
+	slices.SortFunc(list, func(a, b ServiceSummary) int {
+		return strings.Compare(a.Naming, b.Naming)
+	})
+	return list, nil
+}
+
+// ListEndpoints scans the registered endpoints of naming, without discovering it.
+// The naming may be qualified like DiscoveryAndRegister.Discovery, but not a wildcard.
+//
+// Like ListServices, the result isn't a consistent snapshot, an endpoint may be missed
+// while the naming changes, an endpoint scanned twice is listed once.
+func (c *Client) ListEndpoints(ctx context.Context, naming string) ([]*Endpoint, error) {
+	sel, err := parseSelector(naming)
+	if err != nil {
+		return nil, err
+	}
+	if naming = sel.naming; ValidateNaming(naming) != nil {
+		return nil, ErrInvalidNaming
+	}
+
+	var (
+		endpoints []*Endpoint
+		seen      = make(map[string]struct{})
+	)
+	for _, prefix := range keyPrefixes(naming) {
+		if _, err := scanPrefix(ctx, c.client, prefix, func(value *client.Value) bool {
+			endpoint, ok := scannedEndpoint(value)
+			if !ok || (endpoint.Naming != naming && endpoint.Naming != "") || !sel.match(endpoint) {
+				return true
+			}
+			if _, ok = seen[endpoint.ID]; !ok {
+				seen[endpoint.ID] = struct{}{}
+				endpoints = append(endpoints, endpoint)
+			}
+			return true
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return endpoints, nil
+}
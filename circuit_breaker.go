@@ -0,0 +1,46 @@
+package discovery
+
+import (
+	"github.com/RealFax/red-discovery/internal/breaker"
+	"time"
+)
+
+type (
+	CircuitState         = breaker.State
+	CircuitBreakerConfig = breaker.Config
+)
+
+const (
+	CircuitClosed   = breaker.StateClosed
+	CircuitOpen     = breaker.StateOpen
+	CircuitHalfOpen = breaker.StateHalfOpen
+)
+
+var (
+	DefaultCircuitBreakerConfig = CircuitBreakerConfig{
+		ConsecutiveFailures: 5,
+		FailureRatio:        0.5,
+		MinRequests:         20,
+		Window:              time.Second * 10,
+		OpenTimeout:         time.Second * 5,
+		HalfOpenProbes:      1,
+	}
+)
+
+// WithCircuitBreaker set the circuit breaker config of every endpoint in the service.
+//
+// An open endpoint is removed from load balancing, when every endpoint is open,
+// the service fails fast with ErrServiceUnreachable.
+func WithCircuitBreaker(cfg CircuitBreakerConfig) ServiceOption {
+	return func(s *service) {
+		s.breakerCfg.Store(&cfg)
+		s.breakers.Range(func(id string, _ *breaker.Breaker) bool {
+			s.breakers.Store(id, breaker.New(cfg))
+			return true
+		})
+	}
+}
+
+func (s *service) newBreaker() *breaker.Breaker {
+	return breaker.New(*s.breakerCfg.Load())
+}
@@ -0,0 +1,97 @@
+package discovery
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"testing"
+	"time"
+)
+
+var testBreakerConfig = CircuitBreakerConfig{
+	ConsecutiveFailures: 1,
+	OpenTimeout:         200 * time.Millisecond,
+	HalfOpenProbes:      1,
+}
+
+// halfOpen trips the breaker of the endpoint and waits until it is half-open.
+func halfOpen(t *testing.T, srv *service, endpointID string) {
+	t.Helper()
+	b, ok := srv.breakers.Load(endpointID)
+	if !ok {
+		t.Fatalf("no breaker of %s", endpointID)
+	}
+	b.Done(true)
+	time.Sleep(testBreakerConfig.OpenTimeout + 50*time.Millisecond)
+	if state := b.State(); state != CircuitHalfOpen {
+		t.Fatalf("unexpected state: %s", state)
+	}
+}
+
+func TestService_PickGivesBackProbe(t *testing.T) {
+	srv := newTestService(
+		t,
+		[]*testServer{newTestServer(t, reply), newTestServer(t, reply)},
+		WithLazyDial(),
+		WithMaxConnections(1),
+		WithCircuitBreaker(testBreakerConfig),
+	)
+
+	// the only connection of the budget is dialed to node-0
+	b, _ := srv.breakers.Load(testEndpointID(1))
+	b.Done(true)
+	if err := invokeTest(srv); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(testBreakerConfig.OpenTimeout + 50*time.Millisecond)
+
+	// node-1 reserves a probe when picked, but can't get a connection
+	for i := 0; i < 4; i++ {
+		if err := invokeTest(srv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !b.Allow() {
+		t.Fatal("the probe of the unsent calls wasn't given back")
+	}
+}
+
+func TestService_NextAliveConnProbe(t *testing.T) {
+	srv := newTestService(t, []*testServer{newTestServer(t, reply)}, WithCircuitBreaker(testBreakerConfig))
+	halfOpen(t, srv, testEndpointID(0))
+
+	conn, err := srv.NextAliveConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = srv.NextAliveConn(); !errors.Is(err, ErrServiceUnreachable) {
+		t.Fatalf("the probe of the half-open endpoint was handed out twice: %v", err)
+	}
+
+	// the probing call closes the circuit
+	if err = conn.Invoke(context.Background(), testMethod, &emptypb.Empty{}, &emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := srv.CircuitState(testEndpointID(0)); state != CircuitClosed {
+		t.Fatalf("unexpected state: %s", state)
+	}
+	if _, err = srv.NextAliveConn(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestService_ConfigureCircuitBreakerConcurrently(t *testing.T) {
+	srv := newTestService(t, []*testServer{newTestServer(t, reply)})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			srv.Configure(WithCircuitBreaker(testBreakerConfig))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		srv.AddEndpoints(NewEndpoint(testEndpointID(i+1), "127.0.0.1:1", 60, nil))
+	}
+	<-done
+}
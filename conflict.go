@@ -0,0 +1,168 @@
+package discovery
+
+import (
+	"fmt"
+	"github.com/RealFax/RedQueen/client"
+	"github.com/RealFax/red-discovery/internal/hack"
+	"github.com/google/uuid"
+	"sync/atomic"
+)
+
+var (
+	// ownerToken identifies the endpoints registered by this process when conflict detection is used.
+	ownerToken atomic.Pointer[string]
+)
+
+func init() {
+	token := uuid.NewString()
+	ownerToken.Store(&token)
+}
+
+func OwnerToken() string {
+	return *ownerToken.Load()
+}
+
+// SetOwnerToken set the ownership token of the endpoints registered by this process, default is a random UUID.
+//
+// The endpoints of a process restarted with a new token conflict with the ones registered before the restart
+// until they expire, a stable token, e.g. the hostname, reclaims them at once.
+func SetOwnerToken(token string) {
+	if token != "" {
+		ownerToken.Store(&token)
+	}
+}
+
+// EndpointConflictError is returned by Register if conflict detection is used for the naming,
+// and the endpoint ID is owned by another process, or the PeerAddress is registered under another ID.
+type EndpointConflictError struct {
+	Naming string
+	ID     string
+
+	// Conflict is the registered endpoint, it is nil if the registered value isn't an endpoint.
+	Conflict *Endpoint
+}
+
+func (e *EndpointConflictError) Error() string {
+	switch {
+	case e.Conflict == nil:
+		return fmt.Sprintf("sdr: endpoint conflict, %s of %s is registered", e.ID, e.Naming)
+	case e.Conflict.ID != e.ID:
+		return fmt.Sprintf("sdr: endpoint conflict, %s of %s has the address of %s",
+			e.ID, e.Naming, e.Conflict.ID)
+	default:
+		return fmt.Sprintf("sdr: endpoint conflict, %s of %s is owned by %s", e.ID, e.Naming, e.Conflict.Owner)
+	}
+}
+
+func (e *EndpointConflictError) Unwrap() error {
+	return ErrEndpointConflict
+}
+
+func (r *discoveryAndRegister) UseConflictDetection(naming string, enabled bool) {
+	if !enabled {
+		r.conflicts.Delete(naming)
+		return
+	}
+	r.conflicts.Store(naming, struct{}{})
+}
+
+// writeEndpoint writes the endpoint of naming, the endpoint is claimed if conflict detection is used,
+// addresses are the endpoints registered under naming, it is nil unless conflict detection is used, see registeredAddresses.
+func (r *discoveryAndRegister) writeEndpoint(naming string, endpoint *Endpoint, addresses map[string][]*Endpoint) error {
+	if addresses == nil {
+		value, _ := endpoint.Marshal()
+		return r.setEndpoint(naming, endpoint.ID, value, endpoint.TTL())
+	}
+
+	endpoint.Owner = OwnerToken()
+	if err := addressConflict(naming, addresses, endpoint); err != nil {
+		return err
+	}
+
+	value, _ := endpoint.Marshal()
+	if err := r.claimEndpoint(naming, endpoint, value, endpoint.TTL()); err != nil {
+		return err
+	}
+	addresses[endpoint.PeerAddress] = append(addresses[endpoint.PeerAddress], endpoint)
+	return nil
+}
+
+// claimEndpoint writes the value of the endpoint if its primary key doesn't exist, or is owned by the endpoint Owner.
+//
+// Only the write of a missing key is atomic, a key owned by the endpoint is read and then overwritten,
+// another process may claim it in between once it expired. The owner is read again after the write,
+// the claim taken by another process meanwhile is reported as an EndpointConflictError.
+func (r *discoveryAndRegister) claimEndpoint(naming string, endpoint *Endpoint, value []byte, ttl uint32) error {
+	keys := endpointKeys(naming, endpoint.ID)
+	if err := r.kvClient.TrySet(r.ctx, hack.String2Bytes(keys[0]), value, ttl, namespace.Load()); err != nil {
+		owned, cerr := r.ownedBy(naming, endpoint, keys[0])
+		if cerr != nil {
+			return cerr
+		}
+		if !owned {
+			// not written for another reason, or expired since
+			return err
+		}
+		if err = r.setEndpoint(naming, endpoint.ID, value, ttl); err != nil {
+			return err
+		}
+		_, err = r.ownedBy(naming, endpoint, keys[0])
+		return err
+	}
+
+	for _, key := range keys[1:] {
+		if err := r.kvClient.Set(r.ctx, hack.String2Bytes(key), value, ttl, namespace.Load()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownedBy reports whether the key is owned by the endpoint Owner,
+// the registered endpoint of another owner is returned as an EndpointConflictError.
+// It reports false without error if the key can't be read, e.g. it doesn't exist.
+func (r *discoveryAndRegister) ownedBy(naming string, endpoint *Endpoint, key string) (bool, error) {
+	current, err := r.kvClient.Get(r.ctx, hack.String2Bytes(key), namespace.Load())
+	if err != nil {
+		return false, nil
+	}
+	if registered, perr := ParseEndpoint(current.Data); perr != nil || registered.Owner != endpoint.Owner {
+		return false, &EndpointConflictError{Naming: naming, ID: endpoint.ID, Conflict: registered}
+	}
+	return true, nil
+}
+
+// claimedByOther reports whether the primary key of the endpoint is owned by another process.
+func (r *discoveryAndRegister) claimedByOther(naming, id string) bool {
+	current, err := r.kvClient.Get(r.ctx, hack.String2Bytes(EndpointKey(naming, id)), namespace.Load())
+	if err != nil {
+		return false
+	}
+	registered, err := ParseEndpoint(current.Data)
+	return err == nil && registered.Owner != "" && registered.Owner != OwnerToken()
+}
+
+// registeredAddresses scans the endpoints registered under naming by their PeerAddress, once per Register call.
+//
+// The address check is best-effort, it isn't atomic with the writes,
+// two processes registering the same address under different IDs at once may both pass.
+func (r *discoveryAndRegister) registeredAddresses(naming string) (map[string][]*Endpoint, error) {
+	addresses := make(map[string][]*Endpoint)
+	_, err := scanPrefix(r.ctx, r.kvClient, keyPrefixes(naming)[0], func(value *client.Value) bool {
+		if registered, ok := scannedEndpoint(value); ok {
+			addresses[registered.PeerAddress] = append(addresses[registered.PeerAddress], registered)
+		}
+		return true
+	})
+	return addresses, err
+}
+
+// addressConflict returns an EndpointConflictError if the PeerAddress of the endpoint is registered under another ID.
+func addressConflict(naming string, addresses map[string][]*Endpoint, endpoint *Endpoint) error {
+	for _, registered := range addresses[endpoint.PeerAddress] {
+		if registered.ID != endpoint.ID {
+			return &EndpointConflictError{Naming: naming, ID: endpoint.ID, Conflict: registered}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package discovery
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"testing"
+)
+
+// claimAs writes the endpoint to its key as if it is registered by the process of owner.
+func claimAs(t *testing.T, kv *fakeKV, owner string, endpoint *Endpoint) {
+	t.Helper()
+	claimed := *endpoint
+	claimed.Owner = owner
+	value, _ := claimed.Marshal()
+	if err := kv.Set(context.Background(), []byte(EndpointKey(testNaming, endpoint.ID)), value, 60, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegister_ConflictOwner(t *testing.T) {
+	r, kv := newTestRegister(t)
+	r.UseConflictDetection(testNaming, true)
+
+	endpoint := NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)
+	if err := r.Register(testNaming, endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.Owner != OwnerToken() {
+		t.Fatalf("the endpoint isn't claimed: %q", endpoint.Owner)
+	}
+
+	// the key of this process is written again
+	if err := r.Register(testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)); err != nil {
+		t.Fatalf("the endpoint owned by this process conflicts: %v", err)
+	}
+
+	// the key of another process isn't
+	claimAs(t, kv, "other", endpoint)
+	err := r.Register(testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil))
+	var conflict *EndpointConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrEndpointConflict) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conflict.Conflict == nil || conflict.Conflict.Owner != "other" {
+		t.Fatalf("unexpected conflict: %+v", conflict.Conflict)
+	}
+	if value, _ := kv.value(EndpointKey(testNaming, testEndpointID(0))); ownerOf(t, value) != "other" {
+		t.Fatal("the endpoint of another process is overwritten")
+	}
+}
+
+func TestRegister_AddressConflict(t *testing.T) {
+	r, kv := newTestRegister(t)
+	r.UseConflictDetection(testNaming, true)
+
+	if err := r.Register(testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the registered address, and the address of an endpoint of the same batch
+	scans := kv.scans.Load()
+	err := r.Register(testNaming,
+		NewEndpoint(testEndpointID(1), "127.0.0.1:1", 60, nil),
+		NewEndpoint(testEndpointID(2), "127.0.0.1:2", 60, nil),
+		NewEndpoint(testEndpointID(3), "127.0.0.1:2", 60, nil),
+		NewEndpoint(testEndpointID(4), "127.0.0.1:4", 60, nil),
+	)
+	if !errors.Is(err, ErrEndpointConflict) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, registered := range []bool{true, false, true, false, true} {
+		if _, ok := kv.value(EndpointKey(testNaming, testEndpointID(i))); ok != registered {
+			t.Errorf("endpoint %d registered: %v", i, ok)
+		}
+	}
+
+	// the naming is scanned once per Register, a scan reads until an empty page
+	if n := kv.scans.Load() - scans; n != 2 {
+		t.Fatalf("unexpected scans: %d", n)
+	}
+}
+
+func TestKeepAlive_ClaimedByOther(t *testing.T) {
+	r, kv := newTestRegister(t)
+	r.UseConflictDetection(testNaming, true)
+
+	id, _ := r.Grant(5)
+	endpoint := NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)
+	if err := r.RegisterLease(id, testNaming, endpoint); err != nil {
+		t.Fatal(err)
+	}
+	key := EndpointKey(testNaming, testEndpointID(0))
+
+	// the expired key isn't claimed by others, it is taken back
+	kv.expire(key)
+	if err := r.KeepAlive(id); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := kv.value(key); ownerOf(t, value) != OwnerToken() {
+		t.Fatal("the expired endpoint isn't written back")
+	}
+
+	// claimed by another process once expired
+	kv.expire(key)
+	claimAs(t, kv, "other", endpoint)
+	if err := r.KeepAlive(id); !errors.Is(err, ErrEndpointConflict) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value, _ := kv.value(key); ownerOf(t, value) != "other" {
+		t.Fatal("the endpoint claimed by another process is taken back")
+	}
+	if l, _ := r.leases.Load(id); len(l.snapshot()) != 0 {
+		t.Fatal("the endpoint claimed by another process is still refreshed by the lease")
+	}
+	if err := r.KeepAlive(id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ownerOf returns the Owner of the endpoint value.
+func ownerOf(t *testing.T, value []byte) string {
+	t.Helper()
+	endpoint, err := ParseEndpoint(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return endpoint.Owner
+}
+
+func TestRegister_ClaimedDuringWrite(t *testing.T) {
+	useKeyLayout(t, KeyLayoutDual)
+	r, kv := newTestRegister(t)
+	r.UseConflictDetection(testNaming, true)
+
+	endpoint := NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)
+	if err := r.Register(testNaming, endpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	// the primary key is claimed by another process once it is overwritten
+	keys := endpointKeys(testNaming, testEndpointID(0))
+	kv.failWith(func(key string) error {
+		if key == keys[1] {
+			claimAs(t, kv, "other", endpoint)
+		}
+		return nil
+	})
+	err := r.Register(testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil))
+	var conflict *EndpointConflictError
+	if !errors.As(err, &conflict) || conflict.Conflict == nil || conflict.Conflict.Owner != "other" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestUnregister_ClaimedByOther(t *testing.T) {
+	r, kv := newTestRegister(t)
+	r.UseConflictDetection(testNaming, true)
+
+	id, _ := r.Grant(5)
+	endpoint := NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)
+	if err := r.RegisterLease(id, testNaming, endpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	// the key expired and is claimed by another process, the revoked lease doesn't delete it
+	claimAs(t, kv, "other", endpoint)
+	if err := r.Revoke(id); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := kv.value(EndpointKey(testNaming, testEndpointID(0))); !ok || ownerOf(t, value) != "other" {
+		t.Fatal("the endpoint claimed by another process is deleted")
+	}
+}
@@ -33,9 +33,26 @@ func SetNamespace(ns string) {
 }
 
 const (
+	// MaxEndpointSize is the default page size of the registry scans.
 	MaxEndpointSize uint64 = 8192
 )
 
+var scanPageSize atomic.Uint64
+
+func ScanPageSize() uint64 {
+	if size := scanPageSize.Load(); size != 0 {
+		return size
+	}
+	return MaxEndpointSize
+}
+
+// SetScanPageSize set the number of values fetched by each page of the registry scans, default is MaxEndpointSize.
+func SetScanPageSize(size uint64) {
+	if size != 0 {
+		scanPageSize.Store(size)
+	}
+}
+
 type Client struct {
 	ctx      context.Context
 	dialOpts []grpc.DialOption
@@ -48,6 +65,26 @@ func (c *Client) Service(naming string) (Service, bool) {
 	return c.services.Load(naming)
 }
 
+// WaitForService discovers naming if it isn't discovered yet and blocks until at least min endpoints are ready,
+// if ctx is done before, the Service is returned together with the error of ctx.
+// An incomplete discovery keeps watching, the rest endpoints are waited for like the ones scanned.
+func (c *Client) WaitForService(ctx context.Context, naming string, min int) (Service, error) {
+	if err := c.Discovery(naming); err != nil &&
+		!errors.Is(err, ErrDiscoveryHasExist) &&
+		!errors.Is(err, ErrDiscoveryIncomplete) {
+		return nil, err
+	}
+
+	srv, ok := c.services.Load(naming)
+	if !ok {
+		return nil, ErrServiceNotExist
+	}
+	if err := srv.WaitReady(ctx, min); err != nil {
+		return srv, errors.Wrap(err, "sdr: WaitForService")
+	}
+	return srv, nil
+}
+
 func (c *Client) Close() error {
 	c.services.Range(func(key string, value Service) bool {
 		c.services.Delete(key)
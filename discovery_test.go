@@ -3,6 +3,8 @@ package discovery_test
 import (
 	"context"
 	discovery "github.com/RealFax/red-discovery"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"time"
 )
 
 const (
@@ -24,6 +26,19 @@ func init() {
 	}
 }
 
+func ExampleNew() {
+	c, err := discovery.New(context.Background(), []string{
+		"127.0.0.1:5230",
+		"127.0.0.1:4230",
+		"127.0.0.1:3230",
+	}, discovery.DefaultDialOpts...)
+	if err != nil {
+		// handle dial error
+		return
+	}
+	defer c.Close()
+}
+
 func ExampleClient_Service() {
 	srv, found := client.Service(naming)
 	if !found {
@@ -39,3 +54,108 @@ func ExampleClient_Service() {
 	// after the call is completed, conn.Release() should be called to release the connection
 	conn.Target()
 }
+
+func ExampleService_Invoke() {
+	srv, found := client.Service(naming)
+	if !found {
+		// handle service not found error (discovery not called first)
+	}
+
+	resp := &grpc_health_v1.HealthCheckResponse{}
+	if err := srv.Invoke(
+		context.Background(),
+		"/grpc.health.v1.Health/Check",
+		&grpc_health_v1.HealthCheckRequest{},
+		resp,
+		discovery.WithInvokeTimeout(time.Second*3),
+		discovery.WithMaxAttempts(3),
+	); err != nil {
+		// handle error, retryable errors have been retried on other endpoints
+	}
+}
+
+func ExampleWithHedging() {
+	srv, found := client.Service(naming)
+	if !found {
+		// handle service not found error (discovery not called first)
+	}
+
+	// hedge after the p95 latency of the service (50ms until enough samples observed),
+	// at most 5% of the calls will be hedged
+	resp := &grpc_health_v1.HealthCheckResponse{}
+	if err := srv.Invoke(
+		context.Background(),
+		"/grpc.health.v1.Health/Check",
+		&grpc_health_v1.HealthCheckRequest{},
+		resp,
+		discovery.WithHedging(time.Millisecond*50),
+		discovery.WithHedgingPercentile(0.95),
+		discovery.WithHedgingRatio(0.05),
+	); err != nil {
+		// handle error
+	}
+}
+
+func ExampleEndpoint_State() {
+	srv, ok := client.Service(naming)
+	if !ok {
+		return
+	}
+
+	srv.RangeEndpoints(func(endpoint *discovery.Endpoint) bool {
+		switch endpoint.State() {
+		case discovery.EndpointReady:
+			// picked by the load balancing
+		case discovery.EndpointDraining, discovery.EndpointUnhealthy:
+			// not picked for new calls
+		}
+		return true
+	})
+}
+
+func ExampleService_WaitReady() {
+	if err := client.Discovery(naming); err != nil {
+		// handle discovery error
+	}
+
+	srv, ok := client.Service(naming)
+	if !ok {
+		return
+	}
+
+	// endpoints are dialed in background, wait for at least 2 of them
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.WaitReady(ctx, 2); err != nil {
+		// handle timeout, srv.ReadyCount() endpoints are ready
+	}
+}
+
+func ExampleClient_WaitForService() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// block the startup until the dependency has at least 2 ready endpoints
+	srv, err := client.WaitForService(ctx, naming, 2)
+	if err != nil {
+		// handle timeout or discovery error
+		return
+	}
+
+	_ = srv.Invoke(ctx, "/grpc.health.v1.Health/Check", &grpc_health_v1.HealthCheckRequest{}, &grpc_health_v1.HealthCheckResponse{})
+}
+
+func ExampleClient_ListServices() {
+	services, err := client.ListServices(context.Background())
+	if err != nil {
+		// handle scan error
+	}
+
+	for _, summary := range services {
+		endpoints, err := client.ListEndpoints(context.Background(), summary.Naming)
+		if err != nil {
+			// handle scan error
+		}
+		_ = endpoints
+	}
+}
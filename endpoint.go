@@ -1,20 +1,38 @@
 package discovery
 
 import (
+	"bytes"
 	"context"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pkg/errors"
-	"strings"
 	"sync/atomic"
 	"time"
 )
 
 type Endpoint struct {
 	ttl         uint32
+	state       uint32
+	drain       uint32
 	lastUpdated int64
 	ID          string              `json:"id"`
 	PeerAddress string              `json:"peer-addr"`
 	Metadata    jsoniter.RawMessage `json:"metadata,omitempty"`
+
+	// Naming of the endpoint, it is recorded by Register.
+	Naming string `json:"naming,omitempty"`
+
+	// Version, Env and Tenant complete the Identity of the endpoint, they are optional.
+	Version string `json:"version,omitempty"`
+	Env     string `json:"env,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+
+	// Owner is the ownership token of the process registering the endpoint,
+	// it is recorded by Register if conflict detection is used, see UseConflictDetection.
+	Owner string `json:"owner,omitempty"`
+
+	// Draining marks the endpoint is shutting down, register it again with Draining
+	// to stop receiving new calls from the discoverers before unregistering.
+	Draining bool `json:"draining,omitempty"`
 }
 
 func (e *Endpoint) Key() string {
@@ -29,11 +47,19 @@ func (e *Endpoint) Marshal() ([]byte, error) {
 	return jsoniter.ConfigFastest.Marshal(e)
 }
 
+// WithNaming returns the key of the endpoint in the primary key layout, see SetKeyLayout.
 func (e *Endpoint) WithNaming(naming string) string {
-	return strings.Join([]string{
-		naming,
-		e.ID,
-	}, "::")
+	return EndpointKey(naming, e.ID)
+}
+
+// same reports whether o carries the same address, metadata and identity as e.
+func (e *Endpoint) same(o *Endpoint) bool {
+	return e.PeerAddress == o.PeerAddress &&
+		bytes.Equal(e.Metadata, o.Metadata) &&
+		e.Naming == o.Naming &&
+		e.Version == o.Version &&
+		e.Env == o.Env &&
+		e.Tenant == o.Tenant
 }
 
 func (e *Endpoint) SetTTL(ttl uint32) {
@@ -44,8 +70,18 @@ func (e *Endpoint) TTL() uint32 {
 	return atomic.LoadUint32(&e.ttl)
 }
 
+// Expired reports whether the TTL passed since the last update, an endpoint with TTL 0 never expires.
 func (e *Endpoint) Expired() bool {
-	return time.Now().UnixMilli() > e.lastUpdated+(time.Second*time.Duration(e.TTL())).Milliseconds()
+	ttl := e.TTL()
+	if ttl == 0 {
+		return false
+	}
+	return time.Now().UnixMilli() > atomic.LoadInt64(&e.lastUpdated)+(time.Second*time.Duration(ttl)).Milliseconds()
+}
+
+// touch records the time of the last update of the endpoint in unix milli.
+func (e *Endpoint) touch(at int64) {
+	atomic.StoreInt64(&e.lastUpdated, at)
 }
 
 func (e *Endpoint) PutMetadata(md EndpointMetadata) (err error) {
@@ -71,33 +107,24 @@ func ParseEndpoint(b []byte) (*Endpoint, error) {
 	return &endpoint, nil
 }
 
-func ParseEndpointPath(path string) (string, string, error) {
-	s := strings.Split(path, "::")
-	if len(s) != 2 {
-		return "", "", ErrInvalidEndpointPathFormat
-	}
-	return s[0], s[1], nil
-}
-
-func AutoKeepAlive(ctx context.Context, naming string, client *Client, endpoint *Endpoint) error {
-	err := client.Register(naming, endpoint)
+// AutoKeepAlive registers the endpoint with a lease of the endpoint TTL and keeps the lease alive until ctx is done,
+// the lease is revoked then, see KeepAliveLease. To keep many endpoints alive, attach them to one lease by RegisterLease instead.
+func AutoKeepAlive(ctx context.Context, naming string, client *Client, endpoint *Endpoint, opts ...KeepAliveOption) error {
+	lease, err := client.Grant(endpoint.TTL())
 	if err != nil {
 		return errors.Wrap(err, "sdr: AutoKeepAlive")
 	}
+	if err = client.RegisterLease(lease, naming, endpoint); err != nil {
+		_ = client.Revoke(lease)
+		return errors.Wrap(err, "sdr: AutoKeepAlive")
+	}
+	if err = KeepAliveLease(ctx, client, lease, opts...); err != nil {
+		_ = client.Revoke(lease)
+		return err
+	}
 
-	go func() {
-		ticker := time.NewTicker(time.Second * time.Duration(endpoint.TTL()/4))
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				endpoint.lastUpdated = time.Now().UnixMilli()
-				_ = client.Register(naming, endpoint)
-			}
-		}
-	}()
-
+	context.AfterFunc(ctx, func() {
+		_ = client.Revoke(lease)
+	})
 	return nil
 }
@@ -1,8 +1,11 @@
 package discovery
 
 import (
+	"fmt"
 	"github.com/RealFax/red-discovery/internal/maputil"
+	"github.com/RealFax/red-discovery/internal/schema"
 	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
 )
 
 type EndpointMetadata interface {
@@ -50,3 +53,115 @@ func NewKVMetadataFromMap[K comparable, V any](m map[K]V) *KVMetadata[K, V] {
 	kv.Clone(m)
 	return kv
 }
+
+// StandardMetadata is the well-known metadata of an endpoint, it implements EndpointMetadata.
+// Extra holds arbitrary typed fields, they are flattened next to the well-known ones.
+type StandardMetadata struct {
+	// Version of the build, the version used by Discovery is Endpoint.Version.
+	Version  string   `json:"version,omitempty"`
+	Zone     string   `json:"zone,omitempty"`
+	Weight   int      `json:"weight,omitempty"`
+	Protocol string   `json:"protocol,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	BuildSHA string   `json:"build-sha,omitempty"`
+
+	Extra map[string]any `json:"-"`
+}
+
+// standardMetadata has the fields of StandardMetadata without its methods.
+type standardMetadata StandardMetadata
+
+func (m StandardMetadata) MarshalJSON() ([]byte, error) {
+	b, err := jsoniter.ConfigFastest.Marshal((*standardMetadata)(&m))
+	if err != nil || len(m.Extra) == 0 {
+		return b, err
+	}
+
+	fields := make(map[string]any, len(m.Extra))
+	if err = jsoniter.ConfigFastest.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range m.Extra {
+		if _, ok := fields[key]; ok {
+			return nil, errors.Wrapf(ErrInvalidMetadata, "extra field %q overrides a well-known field", key)
+		}
+		fields[key] = value
+	}
+	return jsoniter.ConfigFastest.Marshal(fields)
+}
+
+func (m *StandardMetadata) UnmarshalJSON(b []byte) error {
+	if err := jsoniter.ConfigFastest.Unmarshal(b, (*standardMetadata)(m)); err != nil {
+		return err
+	}
+
+	var fields map[string]any
+	if err := jsoniter.ConfigFastest.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for _, key := range []string{"version", "zone", "weight", "protocol", "tags", "build-sha"} {
+		delete(fields, key)
+	}
+	m.Extra = nil
+	if len(fields) != 0 {
+		m.Extra = fields
+	}
+	return nil
+}
+
+func (m *StandardMetadata) Entry() (jsoniter.RawMessage, error) {
+	return m.MarshalJSON()
+}
+
+// DecodeMetadata unmarshal the metadata of the endpoint into v, e.g. a *StandardMetadata,
+// v is untouched if the endpoint has no metadata.
+func (e *Endpoint) DecodeMetadata(v any) error {
+	if len(e.Metadata) == 0 {
+		return nil
+	}
+	return jsoniter.ConfigFastest.Unmarshal(e.Metadata, v)
+}
+
+type (
+	// MetadataSchema constrains the metadata of the endpoints registered under a Naming,
+	// it is a subset of JSON schema. The keys reserved by this package, e.g. LimitsMetadataKey, are always allowed.
+	MetadataSchema = schema.Schema
+
+	MetadataProperty = schema.Property
+
+	MetadataType = schema.Type
+
+	// MetadataSchemaError is the validation failure of a metadata field, it's wrapped by ErrInvalidMetadata.
+	MetadataSchemaError = schema.Error
+)
+
+const (
+	MetadataAny     = schema.Any
+	MetadataString  = schema.String
+	MetadataNumber  = schema.Number
+	MetadataInteger = schema.Integer
+	MetadataBoolean = schema.Boolean
+	MetadataArray   = schema.Array
+	MetadataObject  = schema.Object
+)
+
+// reservedMetadataKeys are written by this package.
+var reservedMetadataKeys = []string{LimitsMetadataKey}
+
+// validateMetadata validates the metadata of the endpoint, which must be a JSON object if not empty.
+func validateMetadata(s *MetadataSchema, e *Endpoint) error {
+	fields := make(map[string]any)
+	if len(e.Metadata) != 0 {
+		if err := jsoniter.ConfigFastest.Unmarshal(e.Metadata, &fields); err != nil {
+			return errors.Wrap(ErrInvalidMetadata, err.Error())
+		}
+	}
+	for _, key := range reservedMetadataKeys {
+		delete(fields, key)
+	}
+	if err := s.Validate(fields); err != nil {
+		// both ErrInvalidMetadata and *MetadataSchemaError can be inspected
+		return fmt.Errorf("%w: %w", ErrInvalidMetadata, err)
+	}
+	return nil
+}
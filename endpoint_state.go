@@ -0,0 +1,89 @@
+package discovery
+
+import "sync/atomic"
+
+// EndpointState is the lifecycle state of a discovered endpoint.
+type EndpointState uint32
+
+const (
+	// EndpointIdle the endpoint is known but not connected, e.g. it is out of the subset of this client.
+	EndpointIdle EndpointState = iota
+
+	// EndpointConnecting the connection pool of the endpoint is being dialed.
+	EndpointConnecting
+
+	// EndpointReady the endpoint can be picked by the load balancing.
+	EndpointReady
+
+	// EndpointDraining the endpoint is registered with Draining, it doesn't accept new calls.
+	EndpointDraining
+
+	// EndpointUnhealthy the circuit of the endpoint is open, it is only picked to probe its recovery.
+	EndpointUnhealthy
+
+	// EndpointExpired the TTL of the endpoint has expired without being refreshed.
+	EndpointExpired
+)
+
+func (s EndpointState) String() string {
+	switch s {
+	case EndpointIdle:
+		return "idle"
+	case EndpointConnecting:
+		return "connecting"
+	case EndpointReady:
+		return "ready"
+	case EndpointDraining:
+		return "draining"
+	case EndpointUnhealthy:
+		return "unhealthy"
+	case EndpointExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// State returns the lifecycle state of the endpoint, an expired endpoint is always EndpointExpired.
+func (e *Endpoint) State() EndpointState {
+	if e.Expired() {
+		return EndpointExpired
+	}
+	return EndpointState(atomic.LoadUint32(&e.state))
+}
+
+func (e *Endpoint) setDraining(draining bool) {
+	var v uint32
+	if draining {
+		v = 1
+	}
+	atomic.StoreUint32(&e.drain, v)
+}
+
+func (e *Endpoint) draining() bool {
+	return atomic.LoadUint32(&e.drain) == 1
+}
+
+// activeState returns the state of a connected endpoint.
+func (e *Endpoint) activeState() EndpointState {
+	if e.draining() {
+		return EndpointDraining
+	}
+	return EndpointReady
+}
+
+// transition moves the endpoint to the state to if it is in one of the states from,
+// EventEndpointStateChanged is emitted when the state is changed.
+func (s *service) transition(endpoint *Endpoint, to EndpointState, from ...EndpointState) bool {
+	for _, f := range from {
+		if !atomic.CompareAndSwapUint32(&endpoint.state, uint32(f), uint32(to)) {
+			continue
+		}
+		if f != to {
+			s.ready.Notify()
+			s.emit(Event{Type: EventEndpointStateChanged, Endpoint: endpoint})
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package discovery
+
+import (
+	"testing"
+)
+
+func TestService_ZeroTTLNeverExpires(t *testing.T) {
+	ts := newTestServer(t, reply)
+	srv := newTestService(t, nil)
+
+	endpoint := NewEndpoint(testEndpointID(0), ts.addr, 0, nil)
+	endpoint.touch(0)
+	if endpoint.Expired() {
+		t.Fatal("the endpoint with TTL 0 is expired")
+	}
+
+	srv.AddEndpoints(endpoint)
+	eventually(t, func() bool { return endpoint.State() == EndpointReady }, "the endpoint with TTL 0 isn't ready")
+	if err := invokeTest(srv); err != nil {
+		t.Fatalf("the endpoint with TTL 0 isn't picked: %v", err)
+	}
+}
@@ -7,8 +7,17 @@ var (
 	ErrDiscoveryHasExist    = errors.New("sdr: discovery has existed")
 	ErrShouldDiscoveryFirst = errors.New("sdr: should discovery first")
 	ErrServiceUnreachable   = errors.New("sdr: service unreachable")
+	ErrLimitExceeded        = errors.New("sdr: service limit exceeded")
+	ErrDiscoveryIncomplete  = errors.New("sdr: discovery incomplete")
+	ErrLeaseNotFound        = errors.New("sdr: lease not found")
+	ErrInvalidLeaseTTL      = errors.New("sdr: lease ttl must be positive")
 )
 
 var (
 	ErrInvalidEndpointPathFormat = errors.New("sdr: ParseEndpointPath invalid endpoint path format")
+	ErrInvalidNaming             = errors.New("sdr: invalid naming")
+	ErrInvalidEndpointID         = errors.New("sdr: invalid endpoint id")
+	ErrInvalidIdentity           = errors.New("sdr: invalid identity")
+	ErrInvalidMetadata           = errors.New("sdr: invalid metadata")
+	ErrEndpointConflict          = errors.New("sdr: endpoint conflict")
 )
@@ -0,0 +1,74 @@
+package discovery
+
+type EventType int
+
+const (
+	// EventEndpointReady the connections of the endpoint are ready, or dialed on demand by a lazy pool,
+	// the endpoint can be picked.
+	EventEndpointReady EventType = iota
+
+	// EventEndpointDialFailed the endpoint couldn't be dialed and was removed from the service.
+	EventEndpointDialFailed
+
+	// EventEndpointStateChanged the state of the endpoint is changed, see Endpoint.State.
+	EventEndpointStateChanged
+
+	// EventEndpointUpdated the metadata, address or identity of the endpoint is changed,
+	// Endpoint is the updated one, an endpoint of a changed address is redialed.
+	EventEndpointUpdated
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventEndpointReady:
+		return "endpoint-ready"
+	case EventEndpointDialFailed:
+		return "endpoint-dial-failed"
+	case EventEndpointStateChanged:
+		return "endpoint-state-changed"
+	case EventEndpointUpdated:
+		return "endpoint-updated"
+	default:
+		return "unknown"
+	}
+}
+
+// Event of the endpoints of a service.
+type Event struct {
+	Type     EventType
+	Naming   string
+	Endpoint *Endpoint
+
+	// Err is the cause of a failure event.
+	Err error
+}
+
+type EventHandler func(event Event)
+
+// WithEventHandler add a handler of the service events,
+// handlers are called synchronously by the goroutine emitting the event and must not block.
+func WithEventHandler(handler EventHandler) ServiceOption {
+	return func(s *service) {
+		for {
+			prev := s.eventHandlers.Load()
+			next := []EventHandler{handler}
+			if prev != nil {
+				next = append(append(make([]EventHandler, 0, len(*prev)+1), *prev...), handler)
+			}
+			if s.eventHandlers.CompareAndSwap(prev, &next) {
+				return
+			}
+		}
+	}
+}
+
+func (s *service) emit(event Event) {
+	handlers := s.eventHandlers.Load()
+	if handlers == nil {
+		return
+	}
+	event.Naming = s.Naming()
+	for _, handler := range *handlers {
+		handler(event)
+	}
+}
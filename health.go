@@ -0,0 +1,120 @@
+package discovery
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/streamutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync/atomic"
+	"time"
+)
+
+// EndpointStats is a snapshot of the call results of an endpoint.
+type EndpointStats struct {
+	Requests            uint64
+	Failures            uint64
+	ConsecutiveFailures uint64
+}
+
+type endpointStats struct {
+	requests            atomic.Uint64
+	failures            atomic.Uint64
+	consecutiveFailures atomic.Uint64
+}
+
+func (s *endpointStats) observe(err error) {
+	s.requests.Add(1)
+	if !isEndpointFailure(err) {
+		s.consecutiveFailures.Store(0)
+		return
+	}
+	s.failures.Add(1)
+	s.consecutiveFailures.Add(1)
+}
+
+func (s *endpointStats) snapshot() EndpointStats {
+	return EndpointStats{
+		Requests:            s.requests.Load(),
+		Failures:            s.failures.Load(),
+		ConsecutiveFailures: s.consecutiveFailures.Load(),
+	}
+}
+
+// isEndpointFailure reports whether err is caused by the endpoint itself,
+// application level errors are regarded as a successful round trip.
+func isEndpointFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+		return true
+	default:
+		return false
+	}
+}
+
+// begin marks the start of a call made on endpoint, the returned func reports the result of the call.
+func (s *service) begin(endpointID string) func(err error) {
+	start := time.Now()
+	a, _ := s.adaptive.Load(endpointID)
+	if a != nil {
+		a.Begin()
+	}
+	return func(err error) {
+		// abandoned by the caller, e.g. the loser of the hedged calls, it says nothing about the endpoint
+		if status.Code(err) == codes.Canceled {
+			if a != nil {
+				a.Cancel()
+			}
+			s.abandon(endpointID)
+			return
+		}
+
+		rtt := time.Since(start)
+		if a != nil {
+			a.End(rtt, isEndpointFailure(err))
+		}
+		s.report(endpointID, rtt, err)
+	}
+}
+
+// endpointDialOpts returns the dial options of an endpoint,
+// every call made on its connections is reported to the service.
+func (s *service) endpointDialOpts(endpointID string) []grpc.DialOption {
+	opts := make([]grpc.DialOption, 0, len(s.dialOpts)+2)
+	opts = append(opts, s.dialOpts...)
+	return append(
+		opts,
+		grpc.WithChainUnaryInterceptor(func(
+			ctx context.Context,
+			method string,
+			req, reply any,
+			cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker,
+			opts ...grpc.CallOption,
+		) error {
+			done := s.begin(endpointID)
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			done(err)
+			return err
+		}),
+		grpc.WithChainStreamInterceptor(func(
+			ctx context.Context,
+			desc *grpc.StreamDesc,
+			cc *grpc.ClientConn,
+			method string,
+			streamer grpc.Streamer,
+			opts ...grpc.CallOption,
+		) (grpc.ClientStream, error) {
+			done := s.begin(endpointID)
+			stream, err := streamer(ctx, desc, cc, method, opts...)
+			if err != nil {
+				done(err)
+				return nil, err
+			}
+			return streamutil.OnFinish(ctx, desc, stream, done), nil
+		}),
+	)
+}
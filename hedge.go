@@ -0,0 +1,168 @@
+package discovery
+
+import (
+	"context"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHedgingRatio float64 = 0.1
+
+	// maxHedgeTokens limits the burst of hedged requests after a long quiet period.
+	maxHedgeTokens float64 = 10
+)
+
+// hedgeBudget caps the ratio of hedged requests,
+// every call deposits ratio tokens and every hedged request withdraws a whole token.
+type hedgeBudget struct {
+	mu     sync.Mutex
+	tokens float64
+}
+
+func (b *hedgeBudget) deposit(ratio float64) {
+	b.mu.Lock()
+	b.tokens = min(b.tokens+ratio, maxHedgeTokens)
+	b.mu.Unlock()
+}
+
+func (b *hedgeBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// WithHedging enable request hedging, if the first endpoint doesn't respond within delay,
+// the same request is sent to a second endpoint and the first success is taken.
+//
+// Hedging only applies to Invoke, the request must be idempotent.
+func WithHedging(delay time.Duration) InvokeOption {
+	return func(o *invokeOptions) {
+		o.hedgeDelay = delay
+		if o.hedgeRatio == 0 {
+			o.hedgeRatio = DefaultHedgingRatio
+		}
+	}
+}
+
+// WithHedgingPercentile enable request hedging with a delay of the p-th percentile of the observed service latency,
+// the delay set by WithHedging is used until enough samples have been observed, without it requests aren't hedged.
+func WithHedgingPercentile(p float64) InvokeOption {
+	return func(o *invokeOptions) {
+		o.hedgePercentile = p
+		if o.hedgeRatio == 0 {
+			o.hedgeRatio = DefaultHedgingRatio
+		}
+	}
+}
+
+// WithHedgingRatio set the upper bound of hedged requests to all requests, default is DefaultHedgingRatio.
+func WithHedgingRatio(ratio float64) InvokeOption {
+	return func(o *invokeOptions) {
+		o.hedgeRatio = ratio
+	}
+}
+
+func (o *invokeOptions) hedging() bool {
+	return o.hedgeRatio > 0 && (o.hedgeDelay > 0 || o.hedgePercentile > 0)
+}
+
+const minHedgeSamples = 32
+
+// hedgeDelay returns the delay of the hedged request, ok is false if the delay isn't known yet.
+func (s *service) hedgeDelay(o *invokeOptions) (delay time.Duration, ok bool) {
+	if o.hedgePercentile > 0 && s.latency.Len() >= minHedgeSamples {
+		return s.latency.Percentile(o.hedgePercentile), true
+	}
+	return o.hedgeDelay, o.hedgeDelay > 0
+}
+
+// newResponse allocates an empty value of the same type as resp.
+func newResponse(resp any) any {
+	return reflect.New(reflect.TypeOf(resp).Elem()).Interface()
+}
+
+// copyResponse copies the winner of the hedged calls to the caller's resp.
+func copyResponse(dst, src any) {
+	if dm, ok := dst.(proto.Message); ok {
+		proto.Reset(dm)
+		proto.Merge(dm, src.(proto.Message))
+		return
+	}
+	reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src).Elem())
+}
+
+type hedgeResult struct {
+	resp any
+	err  error
+}
+
+// invokeHedged performs a single hedged attempt,
+// the endpoints used by this attempt are added to tried.
+func (s *service) invokeHedged(ctx context.Context, method string, req, resp any, o *invokeOptions, tried map[string]struct{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	launch := func() error {
+		endpoint, conn, reserved, err := s.pick(tried, true)
+		if err != nil {
+			return err
+		}
+		tried[endpoint.ID] = struct{}{}
+
+		go func() {
+			var (
+				out = newResponse(resp)
+				err = conn.Invoke(ctx, method, req, out, o.callOpts...)
+			)
+			reserved.release()
+			results <- hedgeResult{resp: out, err: err}
+		}()
+		return nil
+	}
+
+	if err := launch(); err != nil {
+		return err
+	}
+
+	var (
+		err      error
+		inflight = 1
+		hedge    <-chan time.Time
+	)
+	if delay, ok := s.hedgeDelay(o); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+
+	for inflight > 0 {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-hedge:
+			if s.hedge.withdraw() && launch() == nil {
+				inflight++
+			}
+		case result := <-results:
+			inflight--
+			if result.err == nil {
+				copyResponse(resp, result.resp)
+				return nil
+			}
+			err = result.err
+			if !o.retryable(err) {
+				return err
+			}
+		}
+	}
+	return err
+}
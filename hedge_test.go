@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHedgeBudget(t *testing.T) {
+	var b hedgeBudget
+	b.deposit(0.5)
+	if b.withdraw() {
+		t.Fatal("hedged with less than a token")
+	}
+	b.deposit(0.5)
+	if !b.withdraw() {
+		t.Fatal("the deposited token wasn't withdrawn")
+	}
+	if b.withdraw() {
+		t.Fatal("the token was withdrawn twice")
+	}
+
+	// a quiet period doesn't accumulate more than maxHedgeTokens
+	for i := 0; i < 100; i++ {
+		b.deposit(1)
+	}
+	hedges := 0
+	for b.withdraw() {
+		hedges++
+	}
+	if hedges != int(maxHedgeTokens) {
+		t.Fatalf("unexpected burst of hedges: %d", hedges)
+	}
+}
+
+func TestService_InvokeHedged(t *testing.T) {
+	var (
+		slow = newTestServer(t, delay(time.Minute))
+		fast = newTestServer(t, reply)
+		srv  = newTestService(t, []*testServer{slow, fast})
+	)
+
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		if err := invokeTest(srv, WithHedging(20*time.Millisecond), WithHedgingRatio(1)); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("call %d wasn't hedged, elapsed: %s", i, elapsed)
+		}
+	}
+	if slow.calls.Load() == 0 {
+		t.Fatal("the slow endpoint was never picked")
+	}
+
+	// the canceled losers aren't reported as results of the slow endpoint
+	if stats, _ := srv.Stats(testEndpointID(0)); stats.Requests != 0 {
+		t.Fatalf("the canceled losers were reported: %+v", stats)
+	}
+}
+
+func TestService_InvokeHedgedBudget(t *testing.T) {
+	var (
+		a   = newTestServer(t, delay(100*time.Millisecond))
+		b   = newTestServer(t, delay(100*time.Millisecond))
+		srv = newTestService(t, []*testServer{a, b})
+	)
+
+	// 1 of 4 calls may be hedged
+	for i := 0; i < 8; i++ {
+		if err := invokeTest(srv, WithHedging(time.Millisecond), WithHedgingRatio(0.25)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hedges := a.calls.Load() + b.calls.Load() - 8; hedges != 2 {
+		t.Fatalf("unexpected hedges: %d", hedges)
+	}
+}
+
+func TestService_InvokeHedgedPercentileWithoutSamples(t *testing.T) {
+	var (
+		a   = newTestServer(t, delay(50*time.Millisecond))
+		b   = newTestServer(t, delay(50*time.Millisecond))
+		srv = newTestService(t, []*testServer{a, b})
+	)
+
+	// the percentile isn't known yet, nothing is hedged
+	for i := 0; i < 4; i++ {
+		if err := invokeTest(srv, WithHedgingPercentile(0.5), WithHedgingRatio(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := a.calls.Load() + b.calls.Load(); calls != 4 {
+		t.Fatalf("hedged without a delay, calls: %d", calls)
+	}
+}
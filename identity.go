@@ -0,0 +1,148 @@
+package discovery
+
+import (
+	"github.com/RealFax/red-discovery/internal/grammar"
+	"github.com/RealFax/red-discovery/internal/version"
+	"strings"
+)
+
+const (
+	identityVersionSep   = "@"
+	identityQualifierSep = ";"
+	identityEnvKey       = "env"
+	identityTenantKey    = "tenant"
+)
+
+// Identity is the structured identity of an endpoint, Env and Tenant are optional.
+//
+// The canonical encoding is "<naming>[@<version>][;env=<env>][;tenant=<tenant>]", e.g. "orders@v2.1.0;env=prod".
+//
+// The identity isn't encoded in the key of an endpoint, see EndpointKey for the key of the key layout,
+// it is stored in the value. A version constraint such as ">=2.1" isn't a key prefix, so the qualifiers are
+// matched by the discoverer either way, and an endpoint changing its version updates its key
+// instead of leaving the old key behind until the TTL passes.
+type Identity struct {
+	Naming  string
+	Version string
+	Env     string
+	Tenant  string
+}
+
+func (i Identity) String() string {
+	var b strings.Builder
+	b.WriteString(i.Naming)
+	if i.Version != "" {
+		b.WriteString(identityVersionSep + i.Version)
+	}
+	if i.Env != "" {
+		b.WriteString(identityQualifierSep + identityEnvKey + "=" + i.Env)
+	}
+	if i.Tenant != "" {
+		b.WriteString(identityQualifierSep + identityTenantKey + "=" + i.Tenant)
+	}
+	return b.String()
+}
+
+func (i Identity) Validate() error {
+	if err := ValidateNaming(i.Naming); err != nil {
+		return err
+	}
+	if i.Version != "" {
+		if _, err := version.Parse(i.Version); err != nil {
+			return ErrInvalidIdentity
+		}
+	}
+	if (i.Env != "" && !validQualifier(i.Env)) || (i.Tenant != "" && !validQualifier(i.Tenant)) {
+		return ErrInvalidIdentity
+	}
+	return nil
+}
+
+// validQualifier reports whether an env or a tenant is a valid ID without the separators of the encoding.
+func validQualifier(s string) bool {
+	return grammar.ValidID(s) && !strings.ContainsAny(s, identityVersionSep+identityQualifierSep+"=")
+}
+
+// ParseIdentity parses the canonical encoding of an Identity.
+func ParseIdentity(s string) (Identity, error) {
+	sel, err := parseSelector(s)
+	if err != nil {
+		return Identity{}, err
+	}
+	id := Identity{Naming: sel.naming, Version: sel.version, Env: sel.env, Tenant: sel.tenant}
+	return id, id.Validate()
+}
+
+// Identity returns the identity of the endpoint, the Naming is recorded by Register.
+func (e *Endpoint) Identity() Identity {
+	return Identity{Naming: e.Naming, Version: e.Version, Env: e.Env, Tenant: e.Tenant}
+}
+
+// selector is a Naming to discovery, qualified by a version constraint, an environment or a tenant,
+// e.g. "orders@>=2.1;env=prod" or "pkg.billing.*@v2".
+type selector struct {
+	raw    string
+	naming string
+
+	// qualifiers is the part after the naming, the Service of a discovered naming is naming+qualifiers.
+	qualifiers string
+
+	version    string
+	constraint version.Constraint
+	env        string
+	tenant     string
+}
+
+func parseSelector(s string) (selector, error) {
+	sel := selector{raw: s}
+
+	head, qualifiers, _ := strings.Cut(s, identityQualifierSep)
+	sel.naming, sel.version, _ = strings.Cut(head, identityVersionSep)
+	sel.qualifiers = s[len(sel.naming):]
+
+	if err := validateDiscoveryNaming(sel.naming); err != nil {
+		return selector{}, err
+	}
+	if strings.Contains(head, identityVersionSep) {
+		var err error
+		if sel.constraint, err = version.ParseConstraint(sel.version); err != nil {
+			return selector{}, ErrInvalidIdentity
+		}
+	}
+
+	if qualifiers != "" {
+		for _, qualifier := range strings.Split(qualifiers, identityQualifierSep) {
+			key, value, _ := strings.Cut(qualifier, "=")
+			if !validQualifier(value) {
+				return selector{}, ErrInvalidIdentity
+			}
+			switch key {
+			case identityEnvKey:
+				sel.env = value
+			case identityTenantKey:
+				sel.tenant = value
+			default:
+				return selector{}, ErrInvalidIdentity
+			}
+		}
+	}
+	return sel, nil
+}
+
+// service returns the Service name of a discovered naming.
+func (s selector) service(naming string) string {
+	return naming + s.qualifiers
+}
+
+// covers reports whether the Service name is discovered by the selector.
+func (s selector) covers(service string) bool {
+	naming, ok := strings.CutSuffix(service, s.qualifiers)
+	return ok && grammar.Match(s.naming, naming)
+}
+
+// match reports whether the endpoint is matched by the qualifiers.
+func (s selector) match(e *Endpoint) bool {
+	return (s.constraint == nil || s.constraint.MatchString(e.Version)) &&
+		(s.env == "" || s.env == e.Env) &&
+		(s.tenant == "" || s.tenant == e.Tenant)
+}
@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"testing"
+)
+
+func TestParseIdentity(t *testing.T) {
+	tests := map[string]Identity{
+		"orders":                              {Naming: "orders"},
+		"orders@v2.1.0-rc.1;env=prod":         {Naming: "orders", Version: "v2.1.0-rc.1", Env: "prod"},
+		"orders@v2;env=prod;tenant=acme:eu-1": {Naming: "orders", Version: "v2", Env: "prod", Tenant: "acme:eu-1"},
+	}
+	for s, expected := range tests {
+		id, err := ParseIdentity(s)
+		if err != nil {
+			t.Fatalf("ParseIdentity(%q): %v", s, err)
+		}
+		if id != expected || id.String() != s {
+			t.Errorf("ParseIdentity(%q) = %+v", s, id)
+		}
+	}
+
+	for _, id := range []Identity{
+		{Naming: "orders", Version: "v2.1-rc1"},
+		{Naming: "orders", Env: "a=b"},
+		{Naming: "orders", Tenant: "a@b"},
+	} {
+		if err := id.Validate(); err == nil {
+			t.Errorf("%+v should be invalid", id)
+		}
+	}
+}
+
+func TestDiscovery_PreReleaseVersion(t *testing.T) {
+	r, kv := newTestRegister(t)
+
+	if err := r.Discovery(testNaming + "@>=2.1.0-rc.1"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return kv.watching(1) }, "the naming isn't watched")
+
+	for i, v := range []string{"v2.1.0-rc.1", "v2.1.0-beta", "v2.1.0"} {
+		if err := r.Register(testNaming, &Endpoint{ID: testEndpointID(i), PeerAddress: "127.0.0.1:1", Version: v}); err != nil {
+			t.Fatalf("register %s: %v", v, err)
+		}
+	}
+
+	srv := r.loadService(testNaming + "@>=2.1.0-rc.1")
+	eventually(t, func() bool {
+		_, rc := srv.Endpoint(testEndpointID(0))
+		_, release := srv.Endpoint(testEndpointID(2))
+		return rc && release
+	}, "the matching versions aren't discovered")
+	if _, ok := srv.Endpoint(testEndpointID(1)); ok {
+		t.Fatal("a preceding pre-release is discovered")
+	}
+}
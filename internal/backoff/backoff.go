@@ -0,0 +1,63 @@
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Config of an exponential back-off.
+type Config struct {
+	// Base is the delay of the first retry.
+	Base time.Duration
+
+	// Max caps the delay of the retries.
+	Max time.Duration
+
+	// Multiplier grows the delay after every retry, at least 1.
+	Multiplier float64
+
+	// Jitter randomizes every delay by up to ±Jitter of it, in [0, 1].
+	Jitter float64
+}
+
+// Backoff computes the delays of the retries of an operation.
+type Backoff struct {
+	cfg Config
+}
+
+// Delay returns the delay before the retry of attempt, attempts start from 0.
+func (b *Backoff) Delay(attempt int) time.Duration {
+	if attempt < 0 {
+		attempt = 0
+	}
+	d := float64(b.cfg.Base) * math.Pow(b.cfg.Multiplier, float64(attempt))
+	// the power overflows quickly, cap it before converting back to a duration
+	if d > float64(b.cfg.Max) || math.IsInf(d, 0) || math.IsNaN(d) {
+		d = float64(b.cfg.Max)
+	}
+	return Jitter(time.Duration(d), b.cfg.Jitter)
+}
+
+// Jitter returns d randomized by up to ±fraction of d.
+func Jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || d <= 0 {
+		return d
+	}
+	fraction = min(fraction, 1)
+	return time.Duration(float64(d) * (1 + fraction*(2*rand.Float64()-1)))
+}
+
+func New(cfg Config) *Backoff {
+	if cfg.Base <= 0 {
+		cfg.Base = 100 * time.Millisecond
+	}
+	if cfg.Max < cfg.Base {
+		cfg.Max = max(cfg.Base, 10*time.Second)
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = 2
+	}
+	cfg.Jitter = min(max(cfg.Jitter, 0), 1)
+	return &Backoff{cfg: cfg}
+}
@@ -0,0 +1,80 @@
+package backoff_test
+
+import (
+	"github.com/RealFax/red-discovery/internal/backoff"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := backoff.New(backoff.Config{
+		Base:       100 * time.Millisecond,
+		Max:        time.Second,
+		Multiplier: 2,
+	})
+
+	for attempt, want := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		if d := b.Delay(attempt); d != want {
+			t.Fatalf("unexpected delay of attempt %d, want: %s, got: %s", attempt, want, d)
+		}
+	}
+	if d := b.Delay(1 << 20); d != time.Second {
+		t.Fatalf("overflowed delay should be capped, got: %s", d)
+	}
+	if d := b.Delay(-1); d != 100*time.Millisecond {
+		t.Fatalf("negative attempt should be the first one, got: %s", d)
+	}
+}
+
+func TestBackoff_Defaults(t *testing.T) {
+	b := backoff.New(backoff.Config{})
+	if d := b.Delay(0); d <= 0 {
+		t.Fatalf("default delay should be positive, got: %s", d)
+	}
+	if b.Delay(1) <= b.Delay(0) {
+		t.Fatal("default delay should grow")
+	}
+}
+
+func TestBackoff_Jitter(t *testing.T) {
+	b := backoff.New(backoff.Config{
+		Base:       time.Second,
+		Max:        time.Second,
+		Multiplier: 2,
+		Jitter:     0.2,
+	})
+
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 1000; i++ {
+		d := b.Delay(0)
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("delay out of the jitter range: %s", d)
+		}
+		seen[d] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Fatal("delays aren't randomized")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if d := backoff.Jitter(time.Second, 0); d != time.Second {
+		t.Fatalf("zero fraction should not change the delay, got: %s", d)
+	}
+	if d := backoff.Jitter(0, 0.5); d != 0 {
+		t.Fatalf("zero delay should stay zero, got: %s", d)
+	}
+	for i := 0; i < 1000; i++ {
+		// the fraction is capped to 1, the delay is never negative
+		if d := backoff.Jitter(time.Second, 5); d < 0 || d > 2*time.Second {
+			t.Fatalf("delay out of the jitter range: %s", d)
+		}
+	}
+}
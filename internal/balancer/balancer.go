@@ -1,57 +1,140 @@
 package balancer
 
 import (
-	"github.com/RealFax/red-discovery/internal/maputil"
-	"slices"
+	"github.com/pkg/errors"
+	"sync"
 	"sync/atomic"
 )
 
+var (
+	ErrEmptyBalanceList = errors.New("empty load balance list")
+	ErrNoAvailableNode  = errors.New("no available node in load balance list")
+)
+
 type Node[K comparable, V any] interface {
 	Key() K
 	Value() V
 }
 
 type LoadBalance[K comparable, V any] interface {
+	Size() int32
 	Append(node ...Node[K, V])
 	Remove(key K) bool
+
+	// Replace swaps the node of the same key in place, it returns false if the key doesn't exist.
+	Replace(node Node[K, V]) bool
+
 	Next() (V, error)
+
+	// Pick returns the next node accepted by filter,
+	// nodes are visited in the order of the balancing algorithm and each node is visited at most once.
+	Pick(filter func(V) bool) (V, error)
 }
 
+// snapshot is an immutable view of the balancer members,
+// it is never modified after being published.
+type snapshot[K comparable, V any] struct {
+	nodes []Node[K, V]
+}
+
+// loadBalanceStore is a copy-on-write store,
+// readers load the current snapshot without locking,
+// writers are serialized and publish a new snapshot on every membership change.
 type loadBalanceStore[K comparable, V any] struct {
-	size   atomic.Int32
-	filter *maputil.Map[K, struct{}]
-	nodes  []Node[K, V]
+	mu     sync.Mutex
+	filter map[K]struct{} // guarded by mu
+	snap   atomic.Pointer[snapshot[K, V]]
+}
+
+func (s *loadBalanceStore[K, V]) load() *snapshot[K, V] {
+	return s.snap.Load()
+}
+
+func (s *loadBalanceStore[K, V]) Size() int32 {
+	return int32(len(s.load().nodes))
 }
 
 func (s *loadBalanceStore[K, V]) Append(nodes ...Node[K, V]) {
-	for i, node := range nodes {
-		if _, ok := s.filter.Load(node.Key()); ok {
-			nodes = append(nodes[:i], nodes[i+1:]...)
+	if len(nodes) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.load()
+	next := &snapshot[K, V]{
+		nodes: make([]Node[K, V], len(prev.nodes), len(prev.nodes)+len(nodes)),
+	}
+	copy(next.nodes, prev.nodes)
+
+	for _, node := range nodes {
+		if _, ok := s.filter[node.Key()]; ok {
 			continue
 		}
-		s.filter.Store(node.Key(), struct{}{})
+		s.filter[node.Key()] = struct{}{}
+		next.nodes = append(next.nodes, node)
 	}
 
-	s.nodes = append(s.nodes, nodes...)
-	s.size.Add(int32(len(nodes)))
+	if len(next.nodes) == len(prev.nodes) {
+		return
+	}
+	s.snap.Store(next)
 }
 
 func (s *loadBalanceStore[K, V]) Remove(key K) bool {
-	if _, ok := s.filter.LoadAndDelete(key); !ok {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.filter[key]; !ok {
 		return false
 	}
+	delete(s.filter, key)
 
-	s.nodes = slices.DeleteFunc(s.nodes, func(n Node[K, V]) bool {
-		return n.Key() == key
-	})
+	prev := s.load()
+	next := &snapshot[K, V]{
+		nodes: make([]Node[K, V], 0, len(prev.nodes)-1),
+	}
+	for _, node := range prev.nodes {
+		if node.Key() == key {
+			continue
+		}
+		next.nodes = append(next.nodes, node)
+	}
 
-	s.size.Add(-1)
+	s.snap.Store(next)
+	return true
+}
+
+func (s *loadBalanceStore[K, V]) Replace(node Node[K, V]) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.filter[node.Key()]; !ok {
+		return false
+	}
+
+	prev := s.load()
+	next := &snapshot[K, V]{
+		nodes: make([]Node[K, V], len(prev.nodes)),
+	}
+	for i, n := range prev.nodes {
+		if n.Key() == node.Key() {
+			n = node
+		}
+		next.nodes[i] = n
+	}
+
+	s.snap.Store(next)
 	return true
 }
 
 func newLoadBalanceStore[K comparable, V any]() *loadBalanceStore[K, V] {
-	return &loadBalanceStore[K, V]{
-		filter: maputil.New[K, struct{}](),
-		nodes:  make([]Node[K, V], 0),
+	s := &loadBalanceStore[K, V]{
+		filter: make(map[K]struct{}),
 	}
+	s.snap.Store(&snapshot[K, V]{
+		nodes: make([]Node[K, V], 0),
+	})
+	return s
 }
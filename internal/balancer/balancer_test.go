@@ -0,0 +1,327 @@
+package balancer_test
+
+import (
+	crand "crypto/rand"
+	"github.com/RealFax/red-discovery/internal/balancer"
+	"github.com/pkg/errors"
+	"math/big"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// mutexBalance is a slice based store guarded by a RWMutex, the previous store mutated
+// its slice without any lock, it is the closest safe baseline of the parallel benchmarks.
+type mutexBalance struct {
+	rwm    sync.RWMutex
+	filter map[string]struct{}
+	nodes  []balancer.Node[string, *node]
+}
+
+func (b *mutexBalance) Size() int32 {
+	b.rwm.RLock()
+	defer b.rwm.RUnlock()
+	return int32(len(b.nodes))
+}
+
+func (b *mutexBalance) Append(nodes ...balancer.Node[string, *node]) {
+	b.rwm.Lock()
+	defer b.rwm.Unlock()
+	for _, n := range nodes {
+		if _, ok := b.filter[n.Key()]; ok {
+			continue
+		}
+		b.filter[n.Key()] = struct{}{}
+		b.nodes = append(b.nodes, n)
+	}
+}
+
+func (b *mutexBalance) Remove(key string) bool {
+	b.rwm.Lock()
+	defer b.rwm.Unlock()
+	if _, ok := b.filter[key]; !ok {
+		return false
+	}
+	delete(b.filter, key)
+	b.nodes = slices.DeleteFunc(b.nodes, func(n balancer.Node[string, *node]) bool {
+		return n.Key() == key
+	})
+	return true
+}
+
+func (b *mutexBalance) Replace(n balancer.Node[string, *node]) bool {
+	b.rwm.Lock()
+	defer b.rwm.Unlock()
+	for i := range b.nodes {
+		if b.nodes[i].Key() == n.Key() {
+			b.nodes[i] = n
+			return true
+		}
+	}
+	return false
+}
+
+func (b *mutexBalance) Pick(filter func(*node) bool) (*node, error) {
+	b.rwm.RLock()
+	defer b.rwm.RUnlock()
+	for _, n := range b.nodes {
+		if filter == nil || filter(n.Value()) {
+			return n.Value(), nil
+		}
+	}
+	return nil, balancer.ErrNoAvailableNode
+}
+
+func (b *mutexBalance) Next() (*node, error) {
+	b.rwm.RLock()
+	defer b.rwm.RUnlock()
+	if len(b.nodes) == 0 {
+		return nil, balancer.ErrEmptyBalanceList
+	}
+	idx, _ := crand.Int(crand.Reader, big.NewInt(int64(len(b.nodes))))
+	return b.nodes[idx.Int64()].Value(), nil
+}
+
+func newMutexBalance() balancer.LoadBalance[string, *node] {
+	return &mutexBalance{filter: make(map[string]struct{})}
+}
+
+func newNodes(prefix string, n int) []balancer.Node[string, *node] {
+	nodes := make([]balancer.Node[string, *node], n)
+	for i := range nodes {
+		nodes[i] = &node{
+			name: prefix + strconv.Itoa(i),
+			addr: "address" + strconv.Itoa(i),
+		}
+	}
+	return nodes
+}
+
+func TestLoadBalance_AppendDuplicate(t *testing.T) {
+	for name, lb := range map[string]balancer.LoadBalance[string, *node]{
+		"random":      balancer.NewRandom[string, *node](),
+		"round-robin": balancer.NewRoundRobin[string, *node](),
+	} {
+		t.Run(name, func(t *testing.T) {
+			lb.Append(&node{"node-1", "addr1"}, &node{"node-1", "addr1"}, &node{"node-2", "addr2"})
+			lb.Append(&node{"node-2", "addr2"})
+			if size := lb.Size(); size != 2 {
+				t.Fatalf("unexpected size, want: 2, got: %d", size)
+			}
+		})
+	}
+}
+
+func TestLoadBalance_Remove(t *testing.T) {
+	lb := balancer.NewRandom[string, *node]()
+	lb.Append(newNodes("node-", 3)...)
+
+	if !lb.Remove("node-1") {
+		t.Fatal("remove existed node failed")
+	}
+	if lb.Remove("node-1") {
+		t.Fatal("remove node twice succeeded")
+	}
+	if size := lb.Size(); size != 2 {
+		t.Fatalf("unexpected size, want: 2, got: %d", size)
+	}
+	for i := 0; i < 100; i++ {
+		n, err := lb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.name == "node-1" {
+			t.Fatal("removed node has been picked")
+		}
+	}
+}
+
+func TestLoadBalance_Replace(t *testing.T) {
+	for name, lb := range map[string]balancer.LoadBalance[string, *node]{
+		"random":      balancer.NewRandom[string, *node](),
+		"round-robin": balancer.NewRoundRobin[string, *node](),
+	} {
+		t.Run(name, func(t *testing.T) {
+			lb.Append(newNodes("node-", 3)...)
+
+			if lb.Replace(&node{"node-3", "address3"}) {
+				t.Fatal("replace absent node succeeded")
+			}
+			if !lb.Replace(&node{"node-1", "replaced"}) {
+				t.Fatal("replace existed node failed")
+			}
+			if size := lb.Size(); size != 3 {
+				t.Fatalf("unexpected size, want: 3, got: %d", size)
+			}
+
+			n, err := lb.Pick(func(n *node) bool { return n.name == "node-1" })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.addr != "replaced" {
+				t.Fatalf("replaced node was not picked, got: %s", n.addr)
+			}
+		})
+	}
+}
+
+func TestLoadBalance_Empty(t *testing.T) {
+	lb := balancer.NewRandom[string, *node]()
+	if _, err := lb.Next(); !errors.Is(err, balancer.ErrEmptyBalanceList) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lb.Append(&node{"node-1", "addr1"})
+	lb.Remove("node-1")
+	if _, err := lb.Next(); !errors.Is(err, balancer.ErrEmptyBalanceList) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadBalance_Pick(t *testing.T) {
+	for name, lb := range map[string]balancer.LoadBalance[string, *node]{
+		"random":      balancer.NewRandom[string, *node](),
+		"round-robin": balancer.NewRoundRobin[string, *node](),
+	} {
+		t.Run(name, func(t *testing.T) {
+			lb.Append(newNodes("node-", 8)...)
+			for i := 0; i < 100; i++ {
+				n, err := lb.Pick(func(n *node) bool { return n.name == "node-5" })
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n.name != "node-5" {
+					t.Fatalf("unexpected node picked: %s", n.name)
+				}
+			}
+			if _, err := lb.Pick(func(*node) bool { return false }); !errors.Is(err, balancer.ErrNoAvailableNode) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestLoadBalance_ConcurrentChurn should be run with -race,
+// it mixes membership changes with concurrent picks.
+func TestLoadBalance_ConcurrentChurn(t *testing.T) {
+	var (
+		lb     = balancer.NewRandom[string, *node]()
+		stable = newNodes("stable-", 8)
+		churn  = newNodes("churn-", 64)
+		stop   = make(chan struct{})
+		wg     sync.WaitGroup
+	)
+	lb.Append(stable...)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				n := churn[r.Intn(len(churn))]
+				if r.Intn(2) == 0 {
+					lb.Append(n)
+				} else {
+					lb.Remove(n.Key())
+				}
+			}
+		}(int64(i))
+	}
+
+	var readers sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for j := 0; j < 2000; j++ {
+				n, err := lb.Next()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n == nil {
+					t.Error("nil node picked")
+					return
+				}
+			}
+		}()
+	}
+	readers.Wait()
+	close(stop)
+	wg.Wait()
+
+	for _, n := range churn {
+		lb.Remove(n.Key())
+	}
+	if size := lb.Size(); size != int32(len(stable)) {
+		t.Fatalf("unexpected size, want: %d, got: %d", len(stable), size)
+	}
+}
+
+func benchmarkParallelNext(b *testing.B, lb balancer.LoadBalance[string, *node]) {
+	lb.Append(newNodes("node-", 64)...)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = lb.Next()
+		}
+	})
+}
+
+func benchmarkParallelChurn(b *testing.B, lb balancer.LoadBalance[string, *node]) {
+	var (
+		churn = newNodes("churn-", 16)
+		stop  = make(chan struct{})
+		done  = make(chan struct{})
+	)
+	lb.Append(newNodes("node-", 64)...)
+
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n := churn[i%len(churn)]
+			lb.Append(n)
+			lb.Remove(n.Key())
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = lb.Next()
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+func BenchmarkLoadBalance_Next_Parallel(b *testing.B) {
+	b.Run("cow", func(b *testing.B) {
+		benchmarkParallelNext(b, balancer.NewRandom[string, *node]())
+	})
+	b.Run("mutex", func(b *testing.B) {
+		benchmarkParallelNext(b, newMutexBalance())
+	})
+}
+
+func BenchmarkLoadBalance_NextChurn_Parallel(b *testing.B) {
+	b.Run("cow", func(b *testing.B) {
+		benchmarkParallelChurn(b, balancer.NewRandom[string, *node]())
+	})
+	b.Run("mutex", func(b *testing.B) {
+		benchmarkParallelChurn(b, newMutexBalance())
+	})
+}
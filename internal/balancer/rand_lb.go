@@ -2,7 +2,6 @@ package balancer
 
 import (
 	"crypto/rand"
-	"github.com/pkg/errors"
 	"math/big"
 )
 
@@ -11,13 +10,28 @@ type randomBalance[K comparable, V any] struct {
 }
 
 func (b *randomBalance[K, V]) Next() (V, error) {
-	size := b.size.Load()
+	return b.Pick(nil)
+}
+
+func (b *randomBalance[K, V]) Pick(filter func(V) bool) (V, error) {
+	var (
+		empty V
+		nodes = b.load().nodes
+		size  = int64(len(nodes))
+	)
 	if size == 0 {
-		var empty V
-		return empty, errors.New("empty load balance list")
+		return empty, ErrEmptyBalanceList
+	}
+
+	idx, _ := rand.Int(rand.Reader, big.NewInt(size))
+	start := idx.Int64()
+	for i := int64(0); i < size; i++ {
+		value := nodes[(start+i)%size].Value()
+		if filter == nil || filter(value) {
+			return value, nil
+		}
 	}
-	idx, _ := rand.Int(rand.Reader, big.NewInt(int64(size)))
-	return b.nodes[idx.Int64()].Value(), nil
+	return empty, ErrNoAvailableNode
 }
 
 func NewRandom[K comparable, V any]() LoadBalance[K, V] {
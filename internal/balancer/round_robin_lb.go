@@ -1,34 +1,54 @@
 package balancer
 
 import (
-	"github.com/pkg/errors"
+	"crypto/rand"
+	"encoding/binary"
 	"sync/atomic"
 )
 
+// roundRobinBalance picks nodes in order, the cursor is monotonic and never rewound on Remove,
+// so concurrent callers always observe a valid index of the snapshot they loaded.
 type roundRobinBalance[K comparable, V any] struct {
-	current atomic.Int32
+	current atomic.Uint64
 	*loadBalanceStore[K, V]
 }
 
 func (b *roundRobinBalance[K, V]) Next() (V, error) {
-	size := b.size.Load()
+	return b.Pick(nil)
+}
+
+func (b *roundRobinBalance[K, V]) Pick(filter func(V) bool) (V, error) {
+	var (
+		empty V
+		nodes = b.load().nodes
+		size  = uint64(len(nodes))
+	)
 	if size == 0 {
-		var empty V
-		return empty, errors.New("empty load balance list")
+		return empty, ErrEmptyBalanceList
+	}
+
+	start := b.current.Add(1) - 1
+	for i := uint64(0); i < size; i++ {
+		value := nodes[(start+i)%size].Value()
+		if filter == nil || filter(value) {
+			return value, nil
+		}
 	}
-	next := b.current.Add(1) % size
-	b.current.CompareAndSwap(b.current.Load(), next)
-	return b.nodes[next].Value(), nil
+	return empty, ErrNoAvailableNode
 }
 
-func (b *roundRobinBalance[K, V]) Remove(key K) bool {
-	if !b.loadBalanceStore.Remove(key) {
-		return false
+// randomOffset returns a random start cursor,
+// which prevents a fleet of clients from all hitting the first node at the same time.
+func randomOffset() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0
 	}
-	b.current.Add(-1)
-	return true
+	return binary.LittleEndian.Uint64(b[:])
 }
 
 func NewRoundRobin[K comparable, V any]() LoadBalance[K, V] {
-	return &roundRobinBalance[K, V]{loadBalanceStore: newLoadBalanceStore[K, V]()}
+	b := &roundRobinBalance[K, V]{loadBalanceStore: newLoadBalanceStore[K, V]()}
+	b.current.Store(randomOffset())
+	return b
 }
@@ -3,6 +3,8 @@ package balancer_test
 import (
 	"github.com/RealFax/red-discovery/internal/balancer"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -45,3 +47,145 @@ func BenchmarkRoundRobinBalance_Next_Parallel(b *testing.B) {
 		}
 	})
 }
+
+// chiSquare returns the chi-square statistic of the observed counts against a uniform distribution.
+func chiSquare(counts map[string]int) float64 {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	var (
+		expected = float64(total) / float64(len(counts))
+		stat     float64
+	)
+	for _, c := range counts {
+		d := float64(c) - expected
+		stat += d * d / expected
+	}
+	return stat
+}
+
+// critical value of the chi-square distribution with 7 degrees of freedom at p = 0.001
+const chiSquareCritical7 = 24.322
+
+func TestRoundRobinBalance_Distribution(t *testing.T) {
+	var (
+		lb     = balancer.NewRoundRobin[string, *node]()
+		nodes  = newNodes("node-", 8)
+		counts = make(map[string]int)
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	lb.Append(nodes...)
+
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int)
+			for j := 0; j < 8000; j++ {
+				n, err := lb.Next()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				local[n.name]++
+			}
+			mu.Lock()
+			for k, v := range local {
+				counts[k] += v
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// the cursor is shared, every node must be picked exactly the same amount of times
+	for name, c := range counts {
+		if c != 16*8000/len(nodes) {
+			t.Fatalf("uneven distribution, node: %s, picked: %d", name, c)
+		}
+	}
+}
+
+func TestRoundRobinBalance_DistributionChurn(t *testing.T) {
+	var (
+		lb     = balancer.NewRoundRobin[string, *node]()
+		stable = newNodes("stable-", 8)
+		churn  = newNodes("churn-", 8)
+		counts = make(map[string]int)
+		stop   = make(chan struct{})
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	lb.Append(stable...)
+	for _, n := range stable {
+		counts[n.Key()] = 0
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n := churn[i%len(churn)]
+			lb.Append(n)
+			lb.Remove(n.Key())
+		}
+	}()
+
+	var readers sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			local := make(map[string]int)
+			for j := 0; j < 8000; j++ {
+				n, err := lb.Next()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if strings.HasPrefix(n.name, "stable-") {
+					local[n.name]++
+				}
+			}
+			mu.Lock()
+			for k, v := range local {
+				counts[k] += v
+			}
+			mu.Unlock()
+		}()
+	}
+	readers.Wait()
+	close(stop)
+	wg.Wait()
+
+	if stat := chiSquare(counts); stat > chiSquareCritical7 {
+		t.Fatalf("distribution is not uniform, chi-square: %.3f, counts: %v", stat, counts)
+	}
+}
+
+func TestRoundRobinBalance_RandomOffset(t *testing.T) {
+	var (
+		nodes = newNodes("node-", 16)
+		first = make(map[string]struct{})
+	)
+	for i := 0; i < 64; i++ {
+		lb := balancer.NewRoundRobin[string, *node]()
+		lb.Append(nodes...)
+		n, _ := lb.Next()
+		first[n.name] = struct{}{}
+	}
+	if len(first) == 1 {
+		t.Fatal("every balancer started from the same node")
+	}
+}
+
+func BenchmarkRoundRobinBalance_NextChurn_Parallel(b *testing.B) {
+	benchmarkParallelChurn(b, balancer.NewRoundRobin[string, *node]())
+}
@@ -0,0 +1,179 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State uint8
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type Config struct {
+	// ConsecutiveFailures trips the breaker after n consecutive failures, 0 disables it.
+	ConsecutiveFailures uint32
+
+	// FailureRatio trips the breaker when the failure ratio of a window reaches it, 0 disables it.
+	FailureRatio float64
+
+	// MinRequests is the minimum number of requests in a window before FailureRatio is applied.
+	MinRequests uint32
+
+	// Window is the period of the counters used by FailureRatio.
+	Window time.Duration
+
+	// OpenTimeout is the time the breaker stays open before switching to half-open.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of requests allowed in half-open,
+	// the breaker closes after the same number of consecutive successes.
+	HalfOpenProbes uint32
+}
+
+// Breaker is a closed/open/half-open circuit breaker.
+type Breaker struct {
+	mu          sync.Mutex
+	cfg         Config
+	state       State
+	openedAt    time.Time
+	windowStart time.Time
+	requests    uint32
+	failures    uint32
+	consecutive uint32
+	probes      uint32
+	probedAt    time.Time
+	successes   uint32
+}
+
+// transition moves an expired open breaker to half-open, must be called with mu held.
+// The half-open probes which aren't reported within OpenTimeout are given back.
+func (b *Breaker) transition(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.state = StateHalfOpen
+		b.probes = 0
+		b.successes = 0
+	}
+	if b.state == StateHalfOpen && b.probes > b.successes && now.Sub(b.probedAt) >= b.cfg.OpenTimeout {
+		b.probes = b.successes
+	}
+	if b.state == StateClosed && b.cfg.Window > 0 && now.Sub(b.windowStart) >= b.cfg.Window {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+}
+
+func (b *Breaker) trip(now time.Time) {
+	b.state = StateOpen
+	b.openedAt = now
+	b.requests = 0
+	b.failures = 0
+	b.consecutive = 0
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transition(time.Now())
+	return b.state
+}
+
+// Ready reports whether the breaker lets traffic through, without reserving a half-open probe.
+func (b *Breaker) Ready() bool {
+	return b.State() != StateOpen
+}
+
+// Allow reports whether a request is allowed, in half-open it reserves one of the probes,
+// the result of an allowed request should be reported by Done.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transition(time.Now())
+
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probes >= max(b.cfg.HalfOpenProbes, 1) {
+			return false
+		}
+		b.probes++
+		b.probedAt = time.Now()
+	}
+	return true
+}
+
+// Cancel gives back the half-open probe reserved by Allow for a request which result isn't reported,
+// e.g. the request isn't sent or is canceled by the caller.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateHalfOpen && b.probes > b.successes {
+		b.probes--
+	}
+}
+
+// Done reports the result of a request.
+func (b *Breaker) Done(failure bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.transition(now)
+
+	switch b.state {
+	case StateOpen:
+		return
+	case StateHalfOpen:
+		if failure {
+			b.trip(now)
+			return
+		}
+		b.successes++
+		if b.successes >= max(b.cfg.HalfOpenProbes, 1) {
+			b.state = StateClosed
+			b.windowStart = now
+			b.consecutive = 0
+		}
+		return
+	}
+
+	b.requests++
+	if !failure {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+
+	switch {
+	case b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures:
+		b.trip(now)
+	case b.cfg.FailureRatio > 0 && b.requests >= max(b.cfg.MinRequests, 1) &&
+		float64(b.failures)/float64(b.requests) >= b.cfg.FailureRatio:
+		b.trip(now)
+	}
+}
+
+func New(cfg Config) *Breaker {
+	return &Breaker{
+		cfg:         cfg,
+		windowStart: time.Now(),
+	}
+}
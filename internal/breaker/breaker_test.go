@@ -0,0 +1,182 @@
+package breaker_test
+
+import (
+	"github.com/RealFax/red-discovery/internal/breaker"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBreaker_ConsecutiveFailures(t *testing.T) {
+	b := breaker.New(breaker.Config{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         time.Hour,
+	})
+
+	b.Done(true)
+	b.Done(true)
+	b.Done(false)
+	b.Done(true)
+	b.Done(true)
+	if state := b.State(); state != breaker.StateClosed {
+		t.Fatalf("a success should reset the consecutive failures, state: %s", state)
+	}
+
+	b.Done(true)
+	if state := b.State(); state != breaker.StateOpen {
+		t.Fatalf("unexpected state: %s", state)
+	}
+	if b.Allow() || b.Ready() {
+		t.Fatal("open breaker allowed a request")
+	}
+}
+
+func TestBreaker_FailureRatio(t *testing.T) {
+	b := breaker.New(breaker.Config{
+		FailureRatio: 0.5,
+		MinRequests:  10,
+		Window:       time.Hour,
+		OpenTimeout:  time.Hour,
+	})
+
+	for i := 0; i < 4; i++ {
+		b.Done(true)
+		b.Done(false)
+	}
+	if state := b.State(); state != breaker.StateClosed {
+		t.Fatalf("breaker tripped before MinRequests, state: %s", state)
+	}
+
+	b.Done(false)
+	b.Done(true)
+	if state := b.State(); state != breaker.StateOpen {
+		t.Fatalf("unexpected state: %s", state)
+	}
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	b := breaker.New(breaker.Config{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Millisecond * 20,
+		HalfOpenProbes:      2,
+	})
+
+	b.Done(true)
+	if b.Ready() {
+		t.Fatal("breaker should be open")
+	}
+
+	time.Sleep(time.Millisecond * 30)
+	if state := b.State(); state != breaker.StateHalfOpen {
+		t.Fatalf("unexpected state: %s", state)
+	}
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("half-open breaker should allow probes")
+	}
+	if b.Allow() {
+		t.Fatal("half-open breaker allowed more than HalfOpenProbes requests")
+	}
+
+	b.Done(false)
+	b.Done(false)
+	if state := b.State(); state != breaker.StateClosed {
+		t.Fatalf("unexpected state: %s", state)
+	}
+}
+
+func TestBreaker_HalfOpenFailure(t *testing.T) {
+	b := breaker.New(breaker.Config{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Millisecond * 20,
+		HalfOpenProbes:      1,
+	})
+
+	b.Done(true)
+	time.Sleep(time.Millisecond * 30)
+	if !b.Allow() {
+		t.Fatal("half-open breaker should allow a probe")
+	}
+	b.Done(true)
+	if state := b.State(); state != breaker.StateOpen {
+		t.Fatalf("failed probe should reopen the breaker, state: %s", state)
+	}
+}
+
+func TestBreaker_Cancel(t *testing.T) {
+	b := breaker.New(breaker.Config{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Millisecond * 20,
+		HalfOpenProbes:      1,
+	})
+
+	b.Cancel()
+	b.Done(true)
+	time.Sleep(time.Millisecond * 30)
+	if !b.Allow() {
+		t.Fatal("half-open breaker should allow a probe")
+	}
+	if b.Allow() {
+		t.Fatal("half-open breaker allowed more than HalfOpenProbes requests")
+	}
+
+	// the probe isn't sent, another request probes instead
+	b.Cancel()
+	b.Cancel()
+	if !b.Allow() {
+		t.Fatal("the canceled probe wasn't given back")
+	}
+	if b.Allow() {
+		t.Fatal("half-open breaker allowed more than HalfOpenProbes requests")
+	}
+	b.Done(false)
+	if state := b.State(); state != breaker.StateClosed {
+		t.Fatalf("unexpected state: %s", state)
+	}
+}
+
+func TestBreaker_UnreportedProbe(t *testing.T) {
+	b := breaker.New(breaker.Config{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Millisecond * 20,
+		HalfOpenProbes:      1,
+	})
+
+	b.Done(true)
+	time.Sleep(time.Millisecond * 30)
+	if !b.Allow() || b.Allow() {
+		t.Fatal("half-open breaker should allow a single probe")
+	}
+
+	// the probe is never reported, it is given back after OpenTimeout
+	time.Sleep(time.Millisecond * 30)
+	if !b.Allow() {
+		t.Fatal("the unreported probe wasn't given back")
+	}
+}
+
+func TestBreaker_Concurrent(t *testing.T) {
+	var (
+		b = breaker.New(breaker.Config{
+			ConsecutiveFailures: 100,
+			FailureRatio:        0.9,
+			MinRequests:         100,
+			Window:              time.Millisecond,
+			OpenTimeout:         time.Millisecond,
+			HalfOpenProbes:      4,
+		})
+		wg sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				if b.Allow() {
+					b.Done((i+j)%3 == 0)
+				}
+				_ = b.State()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
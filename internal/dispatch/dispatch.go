@@ -0,0 +1,286 @@
+package dispatch
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policy decides what Push does when the queue is full.
+type Policy int
+
+const (
+	// Block waits until the queue has room.
+	Block Policy = iota
+
+	// DropOldest discards the oldest pending item.
+	DropOldest
+
+	// DropNewest discards the pushed item.
+	DropNewest
+
+	// Coalesce keeps only the newest pending item, regardless of the buffer size.
+	Coalesce
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Coalesce:
+		return "coalesce"
+	default:
+		return "unknown"
+	}
+}
+
+// Stats of a Queue.
+type Stats struct {
+	Delivered uint64
+	Dropped   uint64
+	Panics    uint64
+
+	// Slow is the number of deliveries which took longer than the slow threshold,
+	// a delivery is counted once it passes the threshold, even if it never finishes.
+	Slow uint64
+
+	// Unacked is the number of deliveries which weren't acknowledged within the ack timeout, see NewAck.
+	Unacked uint64
+	Pending int
+}
+
+type Option func(*config)
+
+type config struct {
+	buffer int
+	policy Policy
+	slow   time.Duration
+	ack    time.Duration
+}
+
+// WithBuffer set the number of pending items, default is 16.
+func WithBuffer(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.buffer = n
+		}
+	}
+}
+
+// WithPolicy set the policy of a full queue, default is Block.
+func WithPolicy(p Policy) Option {
+	return func(c *config) {
+		c.policy = p
+	}
+}
+
+// WithSlowThreshold set the duration after which a delivery is counted as slow, default is 1s.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.slow = d
+		}
+	}
+}
+
+// WithAckTimeout set the time the next item waits for the acknowledgment of the delivered one, default is 10s.
+func WithAckTimeout(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.ack = d
+		}
+	}
+}
+
+// Queue delivers the pushed items to a handler in order on its own goroutine,
+// a panicking handler is recovered and counted, the next item is delivered as usual.
+type Queue[T any] struct {
+	cfg     config
+	handler func(v T, ack func())
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []T
+	closed bool
+	done   chan struct{}
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	panics    atomic.Uint64
+	slow      atomic.Uint64
+	unacked   atomic.Uint64
+}
+
+// Push enqueues v, it returns false if v or an older item was dropped, or the queue is closed.
+func (q *Queue[T]) Push(v T) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	ok := true
+	switch {
+	case q.cfg.policy == Coalesce:
+		if len(q.items) != 0 {
+			q.dropped.Add(uint64(len(q.items)))
+			q.items = q.items[:0]
+			ok = false
+		}
+	case len(q.items) < q.cfg.buffer:
+	case q.cfg.policy == DropNewest:
+		q.dropped.Add(1)
+		return false
+	case q.cfg.policy == DropOldest:
+		var zero T
+		q.items[0] = zero
+		q.items = q.items[1:]
+		q.dropped.Add(1)
+		ok = false
+	default:
+		for len(q.items) >= q.cfg.buffer && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			return false
+		}
+	}
+
+	q.items = append(q.items, v)
+	q.cond.Broadcast()
+	return ok
+}
+
+func (q *Queue[T]) Stats() Stats {
+	q.mu.Lock()
+	pending := len(q.items)
+	q.mu.Unlock()
+	return Stats{
+		Delivered: q.delivered.Load(),
+		Dropped:   q.dropped.Load(),
+		Panics:    q.panics.Load(),
+		Slow:      q.slow.Load(),
+		Unacked:   q.unacked.Load(),
+		Pending:   pending,
+	}
+}
+
+// Close stops the delivery, pending items are discarded and blocked Push return,
+// the item being delivered isn't interrupted. It is safe to Close the queue in the handler.
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		q.items = nil
+		q.cond.Broadcast()
+		close(q.done)
+	}
+	q.mu.Unlock()
+}
+
+func (q *Queue[T]) pop() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	var v T
+	if q.closed {
+		return v, false
+	}
+	v = q.items[0]
+	q.items = q.items[1:]
+	q.cond.Broadcast()
+	return v, true
+}
+
+// deliver returns once v is acknowledged, the ack timeout passed or the queue is closed.
+func (q *Queue[T]) deliver(v T) {
+	var (
+		acked = make(chan struct{})
+		once  sync.Once
+		slow  = time.AfterFunc(q.cfg.slow, func() {
+			q.slow.Add(1)
+		})
+	)
+	defer func() {
+		slow.Stop()
+		q.delivered.Add(1)
+	}()
+
+	if !q.call(v, func() {
+		once.Do(func() {
+			close(acked)
+		})
+	}) {
+		return
+	}
+
+	timer := time.NewTimer(q.cfg.ack)
+	defer timer.Stop()
+	select {
+	case <-acked:
+	case <-q.done:
+	case <-timer.C:
+		q.unacked.Add(1)
+	}
+}
+
+// call reports false if the handler panics.
+func (q *Queue[T]) call(v T, ack func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			q.panics.Add(1)
+			ok = false
+		}
+	}()
+	q.handler(v, ack)
+	return true
+}
+
+func (q *Queue[T]) run() {
+	for {
+		v, ok := q.pop()
+		if !ok {
+			return
+		}
+		q.deliver(v)
+	}
+}
+
+// New returns a Queue delivering to handler, it is closed when ctx is done.
+func New[T any](ctx context.Context, handler func(T), opts ...Option) *Queue[T] {
+	return NewAck(ctx, func(v T, ack func()) {
+		handler(v)
+		ack()
+	}, opts...)
+}
+
+// NewAck returns a Queue delivering to handler, the next item is delivered once handler calls ack,
+// or the ack timeout passed. It is closed when ctx is done.
+func NewAck[T any](ctx context.Context, handler func(v T, ack func()), opts ...Option) *Queue[T] {
+	q := &Queue[T]{
+		cfg: config{
+			buffer: 16,
+			policy: Block,
+			slow:   time.Second,
+			ack:    10 * time.Second,
+		},
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&q.cfg)
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	go q.run()
+	context.AfterFunc(ctx, q.Close)
+	return q
+}
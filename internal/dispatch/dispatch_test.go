@@ -0,0 +1,291 @@
+package dispatch_test
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/dispatch"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector records the delivered items, release gates the handler.
+type collector struct {
+	mu      sync.Mutex
+	items   []int
+	release chan struct{}
+	got     chan int
+}
+
+func newCollector(gated bool) *collector {
+	c := &collector{got: make(chan int, 1024)}
+	if gated {
+		c.release = make(chan struct{})
+	}
+	return c
+}
+
+func (c *collector) handle(v int) {
+	if c.release != nil {
+		<-c.release
+	}
+	c.mu.Lock()
+	c.items = append(c.items, v)
+	c.mu.Unlock()
+	c.got <- v
+}
+
+func (c *collector) wait(t *testing.T, last int) []int {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case v := <-c.got:
+			if v == last {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return append([]int(nil), c.items...)
+			}
+		case <-timeout:
+			t.Fatalf("item %d not delivered", last)
+		}
+	}
+}
+
+func TestQueue_Ordered(t *testing.T) {
+	var (
+		c = newCollector(false)
+		q = dispatch.New(context.Background(), c.handle, dispatch.WithBuffer(4))
+	)
+	defer q.Close()
+
+	for i := 0; i < 100; i++ {
+		q.Push(i)
+	}
+	items := c.wait(t, 99)
+	for i, v := range items {
+		if v != i {
+			t.Fatalf("out of order delivery at %d: %v", i, items)
+		}
+	}
+}
+
+// fill blocks the handler on the first item and pushes n more.
+func fill(t *testing.T, policy dispatch.Policy, n int) (*collector, *dispatch.Queue[int]) {
+	t.Helper()
+	c := newCollector(true)
+	q := dispatch.New(context.Background(), c.handle, dispatch.WithBuffer(2), dispatch.WithPolicy(policy))
+	q.Push(0)
+	for q.Stats().Pending != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i <= n; i++ {
+		q.Push(i)
+	}
+	return c, q
+}
+
+func TestQueue_Policy(t *testing.T) {
+	tests := []struct {
+		policy   dispatch.Policy
+		last     int
+		expected []int
+	}{
+		{dispatch.DropOldest, 5, []int{0, 4, 5}},
+		{dispatch.DropNewest, 2, []int{0, 1, 2}},
+		{dispatch.Coalesce, 5, []int{0, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			c, q := fill(t, tt.policy, 5)
+			defer q.Close()
+
+			if dropped := q.Stats().Dropped; dropped != uint64(6-len(tt.expected)) {
+				t.Fatalf("unexpected dropped: %d", dropped)
+			}
+			close(c.release)
+
+			items := c.wait(t, tt.last)
+			if len(items) != len(tt.expected) {
+				t.Fatalf("expected %v, got: %v", tt.expected, items)
+			}
+			for i := range items {
+				if items[i] != tt.expected[i] {
+					t.Fatalf("expected %v, got: %v", tt.expected, items)
+				}
+			}
+		})
+	}
+}
+
+func TestQueue_Block(t *testing.T) {
+	c := newCollector(true)
+	q := dispatch.New(context.Background(), c.handle, dispatch.WithBuffer(1))
+	defer q.Close()
+
+	q.Push(0)
+	q.Push(1)
+
+	pushed := make(chan struct{})
+	go func() {
+		q.Push(2)
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push to a full queue should block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(c.release)
+	<-pushed
+	if items := c.wait(t, 2); len(items) != 3 {
+		t.Fatalf("blocking queue shouldn't drop, got: %v", items)
+	}
+}
+
+func TestQueue_Panic(t *testing.T) {
+	got := make(chan int, 2)
+	q := dispatch.New(context.Background(), func(v int) {
+		if v == 0 {
+			panic("listener panic")
+		}
+		got <- v
+	})
+	defer q.Close()
+
+	q.Push(0)
+	q.Push(1)
+
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a panicking handler should not stop the queue")
+	}
+	if panics := q.Stats().Panics; panics != 1 {
+		t.Fatalf("unexpected panics: %d", panics)
+	}
+}
+
+func TestQueue_Slow(t *testing.T) {
+	done := make(chan struct{})
+	q := dispatch.New(context.Background(), func(int) {
+		time.Sleep(20 * time.Millisecond)
+		close(done)
+	}, dispatch.WithSlowThreshold(time.Millisecond))
+	defer q.Close()
+
+	q.Push(0)
+	<-done
+	for q.Stats().Delivered == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if slow := q.Stats().Slow; slow != 1 {
+		t.Fatalf("unexpected slow: %d", slow)
+	}
+}
+
+func TestQueue_SlowInFlight(t *testing.T) {
+	release := make(chan struct{})
+	q := dispatch.New(context.Background(), func(int) {
+		<-release
+	}, dispatch.WithSlowThreshold(time.Millisecond))
+	defer q.Close()
+	defer close(release)
+
+	// the stuck delivery is counted before it returns
+	q.Push(0)
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Stats().Slow == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the delivery in flight wasn't counted as slow")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if delivered := q.Stats().Delivered; delivered != 0 {
+		t.Fatalf("unexpected delivered: %d", delivered)
+	}
+}
+
+func TestQueue_Ack(t *testing.T) {
+	var (
+		acks = make(chan func(), 2)
+		q    = dispatch.NewAck(context.Background(), func(_ int, ack func()) {
+			acks <- ack
+		}, dispatch.WithAckTimeout(time.Hour))
+	)
+	defer q.Close()
+
+	q.Push(0)
+	q.Push(1)
+	ack := <-acks
+	select {
+	case <-acks:
+		t.Fatal("the next item was delivered before the ack")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	ack()
+	ack()
+	select {
+	case <-acks:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the next item wasn't delivered after the ack")
+	}
+}
+
+func TestQueue_AckTimeout(t *testing.T) {
+	got := make(chan int, 2)
+	q := dispatch.NewAck(context.Background(), func(v int, _ func()) {
+		got <- v
+	}, dispatch.WithAckTimeout(10*time.Millisecond))
+	defer q.Close()
+
+	// never acknowledged, the queue moves on after the timeout
+	q.Push(0)
+	q.Push(1)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatal("an unacknowledged delivery blocked the queue")
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Stats().Unacked != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected unacked: %d", q.Stats().Unacked)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueue_Close(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newCollector(true)
+	q := dispatch.New(ctx, c.handle, dispatch.WithBuffer(1))
+
+	q.Push(0)
+	q.Push(1)
+
+	pushed := make(chan bool)
+	go func() {
+		pushed <- q.Push(2)
+	}()
+
+	// cancel closes the queue, the blocked push returns
+	cancel()
+	select {
+	case ok := <-pushed:
+		if ok {
+			t.Fatal("push to a closed queue should fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close should release the blocked push")
+	}
+	close(c.release)
+
+	if q.Push(3) {
+		t.Fatal("push to a closed queue should fail")
+	}
+}
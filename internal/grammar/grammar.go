@@ -0,0 +1,63 @@
+package grammar
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Wildcard matches every naming with the prefix before it.
+	Wildcard = "*"
+
+	MaxNamingLen = 253
+	MaxIDLen     = 128
+)
+
+var (
+	// a naming is dot separated segments, e.g. pkg.billing.invoice
+	namingPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9_-]*[A-Za-z0-9])?)*$`)
+)
+
+// ValidNaming reports whether naming is dot separated segments of letters, digits, '-' and '_'.
+func ValidNaming(naming string) bool {
+	return len(naming) != 0 && len(naming) <= MaxNamingLen && namingPattern.MatchString(naming)
+}
+
+// ValidID reports whether id doesn't contain the separators of the key layouts, '/' and "::",
+// spaces or control characters.
+func ValidID(id string) bool {
+	if len(id) == 0 || len(id) > MaxIDLen || strings.Contains(id, "::") {
+		return false
+	}
+	for _, r := range id {
+		if r == '/' || r == utf8.RuneError || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Prefix returns the prefix of a pattern and whether it ends with Wildcard.
+func Prefix(pattern string) (string, bool) {
+	return strings.CutSuffix(pattern, Wildcard)
+}
+
+// ValidPattern reports whether pattern is a valid naming, or a naming prefix followed by Wildcard.
+// Wildcard alone matches every naming.
+func ValidPattern(pattern string) bool {
+	prefix, wildcard := Prefix(pattern)
+	if !wildcard {
+		return ValidNaming(pattern)
+	}
+	return prefix == "" || ValidNaming(strings.TrimSuffix(prefix, "."))
+}
+
+// Match reports whether naming is matched by pattern.
+func Match(pattern, naming string) bool {
+	if prefix, wildcard := Prefix(pattern); wildcard {
+		return strings.HasPrefix(naming, prefix)
+	}
+	return naming == pattern
+}
@@ -0,0 +1,88 @@
+package grammar_test
+
+import (
+	"github.com/RealFax/red-discovery/internal/grammar"
+	"strings"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := map[string]bool{
+		"orders":              true,
+		"pkg.billing.invoice": true,
+		"pkg.order-v2":        true,
+		"pkg_order.v2":        true,
+		"":                    false,
+		"pkg.":                false,
+		".pkg":                false,
+		"pkg..order":          false,
+		"pkg::order":          false,
+		"services/orders":     false,
+		"pkg.*":               false,
+		"-pkg":                false,
+		strings.Repeat("a", grammar.MaxNamingLen+1): false,
+	}
+	for s, expected := range tests {
+		if grammar.ValidNaming(s) != expected {
+			t.Errorf("ValidNaming(%q) should be %v", s, expected)
+		}
+	}
+}
+
+func TestValidID(t *testing.T) {
+	tests := map[string]bool{
+		"5f0c7e0e-7d3b-4b7e-9a53-2c0f0d1e4a6b":  true,
+		"node-1.zone_a":                         true,
+		"10.0.0.1:8080":                         true,
+		"[::1]:8080":                            false,
+		"":                                      false,
+		"a::b":                                  false,
+		"a/b":                                   false,
+		"a b":                                   false,
+		"a\nb":                                  false,
+		"\xff":                                  false,
+		strings.Repeat("a", grammar.MaxIDLen+1): false,
+	}
+	for s, expected := range tests {
+		if grammar.ValidID(s) != expected {
+			t.Errorf("ValidID(%q) should be %v", s, expected)
+		}
+	}
+}
+
+func TestValidPattern(t *testing.T) {
+	tests := map[string]bool{
+		"*":             true,
+		"pkg.billing":   true,
+		"pkg.billing*":  true,
+		"pkg.billing.*": true,
+		"pkg..*":        false,
+		"pkg.*.order":   false,
+		"**":            false,
+	}
+	for s, expected := range tests {
+		if grammar.ValidPattern(s) != expected {
+			t.Errorf("ValidPattern(%q) should be %v", s, expected)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, naming string
+		expected        bool
+	}{
+		{"pkg.order", "pkg.order", true},
+		{"pkg.order", "pkg.orders", false},
+		{"pkg.order", "pkg.order.v2", false},
+		{"pkg.billing.*", "pkg.billing.invoice", true},
+		{"pkg.billing.*", "pkg.billing", false},
+		{"pkg.billing.*", "pkg.billings.invoice", false},
+		{"*", "orders", true},
+	}
+	for _, tt := range tests {
+		if grammar.Match(tt.pattern, tt.naming) != tt.expected {
+			t.Errorf("Match(%q, %q) should be %v", tt.pattern, tt.naming, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package latency
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Window keeps the latest latency samples in a ring buffer.
+type Window struct {
+	mu      sync.Mutex
+	next    int
+	full    bool
+	samples []time.Duration
+}
+
+func (w *Window) Observe(d time.Duration) {
+	w.mu.Lock()
+	w.samples[w.next] = d
+	w.next++
+	if w.next == len(w.samples) {
+		w.next = 0
+		w.full = true
+	}
+	w.mu.Unlock()
+}
+
+// Len returns the number of samples in the window.
+func (w *Window) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.full {
+		return len(w.samples)
+	}
+	return w.next
+}
+
+// Percentile returns the p-th (0 < p <= 1) percentile of the samples in the window,
+// returns 0 if the window is empty.
+func (w *Window) Percentile(p float64) time.Duration {
+	w.mu.Lock()
+	size := w.next
+	if w.full {
+		size = len(w.samples)
+	}
+	sorted := make([]time.Duration, size)
+	copy(sorted, w.samples[:size])
+	w.mu.Unlock()
+
+	if size == 0 {
+		return 0
+	}
+	slices.Sort(sorted)
+
+	switch {
+	case p <= 0:
+		return sorted[0]
+	case p >= 1:
+		return sorted[size-1]
+	}
+	idx := int(float64(size)*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+func NewWindow(size int) *Window {
+	if size <= 0 {
+		size = 1
+	}
+	return &Window{
+		samples: make([]time.Duration, size),
+	}
+}
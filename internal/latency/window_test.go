@@ -0,0 +1,70 @@
+package latency_test
+
+import (
+	"github.com/RealFax/red-discovery/internal/latency"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWindow_Percentile(t *testing.T) {
+	w := latency.NewWindow(100)
+	if p := w.Percentile(0.5); p != 0 {
+		t.Fatalf("empty window percentile should be 0, got: %s", p)
+	}
+
+	for i := 100; i > 0; i-- {
+		w.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	for _, c := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.5, 50 * time.Millisecond},
+		{0.95, 95 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	} {
+		if got := w.Percentile(c.p); got != c.want {
+			t.Errorf("p%v, want: %s, got: %s", c.p*100, c.want, got)
+		}
+	}
+}
+
+func TestWindow_Overwrite(t *testing.T) {
+	w := latency.NewWindow(4)
+	for i := 1; i <= 4; i++ {
+		w.Observe(time.Second)
+	}
+	for i := 1; i <= 4; i++ {
+		w.Observe(time.Millisecond)
+	}
+	if w.Len() != 4 {
+		t.Fatalf("unexpected window length: %d", w.Len())
+	}
+	if p := w.Percentile(1); p != time.Millisecond {
+		t.Fatalf("old samples should be overwritten, got max: %s", p)
+	}
+}
+
+func TestWindow_Concurrent(t *testing.T) {
+	var (
+		w  = latency.NewWindow(64)
+		wg sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				w.Observe(time.Duration(j))
+				_ = w.Percentile(0.9)
+			}
+		}()
+	}
+	wg.Wait()
+	if w.Len() != 64 {
+		t.Fatalf("unexpected window length: %d", w.Len())
+	}
+}
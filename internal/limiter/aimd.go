@@ -0,0 +1,112 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type AIMDConfig struct {
+	// InitialLimit is the concurrency limit before any sample has been observed.
+	InitialLimit int
+
+	// MinLimit and MaxLimit bound the learned limit.
+	MinLimit int
+	MaxLimit int
+
+	// Tolerance regards a request as overloaded when its rtt exceeds Tolerance times the minimum rtt.
+	Tolerance float64
+
+	// BackoffRatio is the multiplicative decrease applied on overload, in (0, 1).
+	BackoffRatio float64
+
+	// ProbeInterval is the number of samples after which the minimum rtt is measured again,
+	// so that a permanent latency shift of the endpoint is learned.
+	ProbeInterval int
+}
+
+// AIMD is an adaptive concurrency limiter,
+// the limit is additively increased while the requests are healthy and fully utilize the limit,
+// and multiplicatively decreased when a request is dropped or its rtt shows queueing.
+type AIMD struct {
+	cfg      AIMDConfig
+	inflight atomic.Int64
+
+	mu      sync.Mutex
+	limit   float64
+	minRTT  time.Duration
+	samples int
+}
+
+func (a *AIMD) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+func (a *AIMD) Inflight() int {
+	return int(a.inflight.Load())
+}
+
+// Available reports whether a new request is under the limit.
+func (a *AIMD) Available() bool {
+	return a.Inflight() < a.Limit()
+}
+
+// Begin marks the start of a request, it must be paired with End.
+func (a *AIMD) Begin() {
+	a.inflight.Add(1)
+}
+
+// Cancel marks the end of a request abandoned by the caller, nothing is learned from it.
+func (a *AIMD) Cancel() {
+	a.inflight.Add(-1)
+}
+
+// End marks the end of a request and learns from its result.
+func (a *AIMD) End(rtt time.Duration, dropped bool) {
+	inflight := a.inflight.Add(-1) + 1
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !dropped {
+		a.samples++
+		if a.minRTT == 0 || rtt < a.minRTT || (a.cfg.ProbeInterval > 0 && a.samples >= a.cfg.ProbeInterval) {
+			a.minRTT = rtt
+			a.samples = 0
+		}
+	}
+
+	switch {
+	case dropped || (a.minRTT > 0 && float64(rtt) > float64(a.minRTT)*a.cfg.Tolerance):
+		a.limit = max(a.limit*a.cfg.BackoffRatio, float64(a.cfg.MinLimit))
+	case float64(inflight)*2 >= a.limit:
+		a.limit = min(a.limit+1, float64(a.cfg.MaxLimit))
+	}
+}
+
+func NewAIMD(cfg AIMDConfig) *AIMD {
+	if cfg.MinLimit < 1 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit < cfg.MinLimit {
+		cfg.MaxLimit = cfg.MinLimit
+	}
+	if cfg.InitialLimit < cfg.MinLimit {
+		cfg.InitialLimit = cfg.MinLimit
+	}
+	if cfg.InitialLimit > cfg.MaxLimit {
+		cfg.InitialLimit = cfg.MaxLimit
+	}
+	if cfg.Tolerance < 1 {
+		cfg.Tolerance = 1
+	}
+	if cfg.BackoffRatio <= 0 || cfg.BackoffRatio >= 1 {
+		cfg.BackoffRatio = 0.9
+	}
+	return &AIMD{
+		cfg:   cfg,
+		limit: float64(cfg.InitialLimit),
+	}
+}
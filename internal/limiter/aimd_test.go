@@ -0,0 +1,125 @@
+package limiter_test
+
+import (
+	"github.com/RealFax/red-discovery/internal/limiter"
+	"sync"
+	"testing"
+	"time"
+)
+
+var aimdConfig = limiter.AIMDConfig{
+	InitialLimit: 10,
+	MinLimit:     1,
+	MaxLimit:     100,
+	Tolerance:    2,
+	BackoffRatio: 0.5,
+}
+
+func TestAIMD_Increase(t *testing.T) {
+	a := limiter.NewAIMD(aimdConfig)
+
+	// saturate the limit with healthy requests
+	for i := 0; i < 20; i++ {
+		for j := 0; j < a.Limit(); j++ {
+			a.Begin()
+		}
+		for j := a.Inflight(); j > 0; j-- {
+			a.End(time.Millisecond, false)
+		}
+	}
+	if limit := a.Limit(); limit <= 10 {
+		t.Fatalf("limit should grow with healthy saturated traffic, got: %d", limit)
+	}
+	if limit := a.Limit(); limit > 100 {
+		t.Fatalf("limit exceeded MaxLimit, got: %d", limit)
+	}
+}
+
+func TestAIMD_NoIncreaseWhenIdle(t *testing.T) {
+	a := limiter.NewAIMD(aimdConfig)
+	for i := 0; i < 100; i++ {
+		a.Begin()
+		a.End(time.Millisecond, false)
+	}
+	if limit := a.Limit(); limit != 10 {
+		t.Fatalf("limit should not grow while underutilized, got: %d", limit)
+	}
+}
+
+func TestAIMD_Decrease(t *testing.T) {
+	a := limiter.NewAIMD(aimdConfig)
+
+	a.Begin()
+	a.End(time.Millisecond, false)
+
+	// queueing, rtt exceeds the tolerance
+	a.Begin()
+	a.End(time.Millisecond*5, false)
+	if limit := a.Limit(); limit != 5 {
+		t.Fatalf("unexpected limit after latency spike: %d", limit)
+	}
+
+	a.Begin()
+	a.End(0, true)
+	if limit := a.Limit(); limit != 2 {
+		t.Fatalf("unexpected limit after drop: %d", limit)
+	}
+
+	for i := 0; i < 10; i++ {
+		a.Begin()
+		a.End(0, true)
+	}
+	if limit := a.Limit(); limit != 1 {
+		t.Fatalf("limit should be bounded by MinLimit, got: %d", limit)
+	}
+}
+
+func TestAIMD_Available(t *testing.T) {
+	a := limiter.NewAIMD(limiter.AIMDConfig{InitialLimit: 2, MaxLimit: 2})
+	a.Begin()
+	if !a.Available() {
+		t.Fatal("limiter should be available")
+	}
+	a.Begin()
+	if a.Available() {
+		t.Fatal("limiter should be saturated")
+	}
+}
+
+func TestAIMD_Cancel(t *testing.T) {
+	a := limiter.NewAIMD(limiter.AIMDConfig{InitialLimit: 2, MaxLimit: 2, Tolerance: 2, BackoffRatio: 0.5})
+	a.Begin()
+	a.Begin()
+	a.Cancel()
+	if !a.Available() || a.Inflight() != 1 {
+		t.Fatalf("canceled request is still in flight: %d", a.Inflight())
+	}
+
+	// a canceled request isn't learned as dropped
+	a.Cancel()
+	if limit := a.Limit(); limit != 2 {
+		t.Fatalf("limit changed by canceled requests: %d", limit)
+	}
+}
+
+func TestAIMD_Concurrent(t *testing.T) {
+	var (
+		a  = limiter.NewAIMD(aimdConfig)
+		wg sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				a.Begin()
+				_ = a.Available()
+				a.End(time.Duration(j%7)*time.Microsecond, (i+j)%50 == 0)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if a.Inflight() != 0 {
+		t.Fatalf("inflight leaked: %d", a.Inflight())
+	}
+}
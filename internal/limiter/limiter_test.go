@@ -0,0 +1,96 @@
+package limiter_test
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/limiter"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Allow(t *testing.T) {
+	b := limiter.NewTokenBucket(1, 3)
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("token %d should be available", i)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("bucket should be empty")
+	}
+}
+
+func TestTokenBucket_Wait(t *testing.T) {
+	b := limiter.NewTokenBucket(100, 1)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*40 {
+		t.Fatalf("rate has not been limited, elapsed: %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	slow := limiter.NewTokenBucket(0.001, 1)
+	_ = slow.Allow()
+	if err := slow.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	var (
+		sem     = limiter.NewSemaphore(4)
+		current atomic.Int32
+		peak    atomic.Int32
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sem.Acquire(context.Background()); err != nil {
+				t.Error(err)
+				return
+			}
+			defer sem.Release()
+
+			n := current.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			current.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > 4 {
+		t.Fatalf("semaphore exceeded, peak: %d", p)
+	}
+	if sem.InUse() != 0 {
+		t.Fatalf("slots leaked: %d", sem.InUse())
+	}
+}
+
+func TestSemaphore_TryAcquire(t *testing.T) {
+	sem := limiter.NewSemaphore(1)
+	if !sem.TryAcquire() {
+		t.Fatal("first acquire should succeed")
+	}
+	if sem.TryAcquire() {
+		t.Fatal("second acquire should fail")
+	}
+	sem.Release()
+	if !sem.TryAcquire() {
+		t.Fatal("acquire after release should succeed")
+	}
+}
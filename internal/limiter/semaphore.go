@@ -0,0 +1,47 @@
+package limiter
+
+import "context"
+
+// Semaphore limits the number of concurrent holders.
+type Semaphore struct {
+	slots chan struct{}
+}
+
+func (s *Semaphore) TryAcquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Semaphore) Release() {
+	select {
+	case <-s.slots:
+	default:
+	}
+}
+
+// InUse returns the number of acquired slots.
+func (s *Semaphore) InUse() int {
+	return len(s.slots)
+}
+
+func NewSemaphore(size int) *Semaphore {
+	if size < 1 {
+		size = 1
+	}
+	return &Semaphore{
+		slots: make(chan struct{}, size),
+	}
+}
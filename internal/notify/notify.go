@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Notifier broadcasts changes to the goroutines waiting for them.
+type Notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// Changed returns a channel closed by the next Notify.
+func (n *Notifier) Changed() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+// Notify wakes all the goroutines waiting on Changed.
+func (n *Notifier) Notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// WaitUntil blocks until cond returns true, cond is checked again after every Notify.
+func (n *Notifier) WaitUntil(ctx context.Context, cond func() bool) error {
+	for {
+		// subscribe before checking, a Notify between the check and the select isn't lost
+		changed := n.Changed()
+		if cond() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
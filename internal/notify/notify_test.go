@@ -0,0 +1,88 @@
+package notify_test
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/notify"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotifier_Broadcast(t *testing.T) {
+	var (
+		n       notify.Notifier
+		wg      sync.WaitGroup
+		changed = n.Changed()
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-changed
+		}()
+	}
+	n.Notify()
+	wg.Wait()
+
+	select {
+	case <-n.Changed():
+		t.Fatal("a new subscription shouldn't observe a past notify")
+	default:
+	}
+}
+
+func TestNotifier_WaitUntil(t *testing.T) {
+	var (
+		n     notify.Notifier
+		count atomic.Int64
+		wg    sync.WaitGroup
+	)
+
+	const (
+		writers = 16
+		min     = writers * 100
+	)
+
+	waiters := make(chan error, 8)
+	for i := 0; i < cap(waiters); i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			waiters <- n.WaitUntil(ctx, func() bool {
+				return count.Load() >= min
+			})
+		}()
+	}
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				count.Add(1)
+				n.Notify()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < cap(waiters); i++ {
+		if err := <-waiters; err != nil {
+			t.Fatalf("waiter missed the notify: %v", err)
+		}
+	}
+}
+
+func TestNotifier_WaitUntilCanceled(t *testing.T) {
+	var n notify.Notifier
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := n.WaitUntil(ctx, func() bool { return false })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+package pool
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/streamutil"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrPoolClosed    = errors.New("connection pool closed")
+	ErrPoolExhausted = errors.New("connection budget exhausted")
+)
+
+// Budget bounds the total number of connections shared by multiple pools.
+type Budget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+func (b *Budget) take() bool {
+	if b == nil {
+		return true
+	}
+	for {
+		used := b.used.Load()
+		if used >= b.limit {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+1) {
+			return true
+		}
+	}
+}
+
+func (b *Budget) give(n int) {
+	if b != nil {
+		b.used.Add(-int64(n))
+	}
+}
+
+// Used returns the number of connections taken from the budget.
+func (b *Budget) Used() int {
+	return int(b.used.Load())
+}
+
+func NewBudget(limit int) *Budget {
+	return &Budget{limit: int64(limit)}
+}
+
+type Option func(*Pool)
+
+// WithSize set the maximum number of connections of the pool, default is 1.
+func WithSize(size int) Option {
+	return func(p *Pool) {
+		if size > 0 {
+			p.size = size
+		}
+	}
+}
+
+// WithLazy defers dialing until the connections are requested by Get.
+func WithLazy() Option {
+	return func(p *Pool) {
+		p.lazy = true
+	}
+}
+
+// WithBudget shares a connection budget with other pools.
+func WithBudget(b *Budget) Option {
+	return func(p *Pool) {
+		p.budget = b
+	}
+}
+
+// WithDialTimeout bounds the time of dialing a connection, it only takes effect with a blocking dial.
+func WithDialTimeout(d time.Duration) Option {
+	return func(p *Pool) {
+		p.dialTimeout = d
+	}
+}
+
+// retired marks a connection evicted from the pool in conn.state, the lower bits count the calls in flight.
+const retired int64 = 1 << 62
+
+// conn counts the calls in flight on a connection, an evicted connection is closed once they are finished.
+type conn struct {
+	*grpc.ClientConn
+	budget *Budget
+	state  atomic.Int64
+	once   sync.Once
+}
+
+func (c *conn) begin() {
+	c.state.Add(1)
+}
+
+func (c *conn) end() {
+	if c.state.Add(-1) == retired {
+		c.close()
+	}
+}
+
+func (c *conn) inUse() bool {
+	return c.state.Load()&^retired != 0
+}
+
+// retire closes the connection once the calls in flight are finished.
+func (c *conn) retire() {
+	for {
+		state := c.state.Load()
+		if c.state.CompareAndSwap(state, state|retired) {
+			if state == 0 {
+				c.close()
+			}
+			return
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		_ = c.ClientConn.Close()
+		c.budget.give(1)
+	})
+}
+
+func (c *conn) unaryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	c.begin()
+	defer c.end()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (c *conn) streamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	c.begin()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		c.end()
+		return nil, err
+	}
+	return streamutil.OnFinish(ctx, desc, stream, func(error) {
+		c.end()
+	}), nil
+}
+
+// Pool is a set of grpc connections to the same target,
+// connections are dialed up to size on demand and handed out in round-robin.
+type Pool struct {
+	ctx         context.Context
+	target      string
+	dialOpts    []grpc.DialOption
+	size        int
+	lazy        bool
+	budget      *Budget
+	dialTimeout time.Duration
+
+	mu       sync.Mutex // serializes grow, Evict and Close
+	closed   bool
+	conns    atomic.Pointer[[]*conn]
+	next     atomic.Uint64
+	lastUsed atomic.Int64
+}
+
+func (p *Pool) Target() string {
+	return p.target
+}
+
+// Len returns the number of dialed connections.
+func (p *Pool) Len() int {
+	return len(*p.conns.Load())
+}
+
+// Idle reports whether the pool holds connections which haven't been used for d,
+// a pool with calls in flight, e.g. a long-lived stream, is never idle.
+func (p *Pool) Idle(d time.Duration) bool {
+	conns := *p.conns.Load()
+	if len(conns) == 0 || time.Since(time.Unix(0, p.lastUsed.Load())) < d {
+		return false
+	}
+	for _, c := range conns {
+		if c.inUse() {
+			return false
+		}
+	}
+	return true
+}
+
+// grow dials one more connection if the pool and the budget allow,
+// it returns the connections of the pool, which is never empty on success.
+func (p *Pool) grow() ([]*conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	prev := *p.conns.Load()
+	if len(prev) >= p.size {
+		return prev, nil
+	}
+	if !p.budget.take() {
+		if len(prev) == 0 {
+			return nil, ErrPoolExhausted
+		}
+		return prev, nil
+	}
+
+	ctx := p.ctx
+	if p.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.dialTimeout)
+		defer cancel()
+	}
+
+	c := &conn{budget: p.budget}
+	dialOpts := make([]grpc.DialOption, 0, len(p.dialOpts)+2)
+	dialOpts = append(dialOpts, p.dialOpts...)
+	dialOpts = append(
+		dialOpts,
+		grpc.WithChainUnaryInterceptor(c.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(c.streamInterceptor),
+	)
+
+	var err error
+	if c.ClientConn, err = grpc.DialContext(ctx, p.target, dialOpts...); err != nil {
+		p.budget.give(1)
+		if len(prev) == 0 {
+			return nil, err
+		}
+		return prev, nil
+	}
+
+	next := make([]*conn, len(prev), len(prev)+1)
+	copy(next, prev)
+	next = append(next, c)
+	p.conns.Store(&next)
+	return next, nil
+}
+
+// Get returns a connection of the pool, dialing a new one while the pool is not full.
+//
+// DON'T CLOSE GRPC CONN
+func (p *Pool) Get() (*grpc.ClientConn, error) {
+	p.lastUsed.Store(time.Now().UnixNano())
+
+	conns := *p.conns.Load()
+	if len(conns) < p.size {
+		var err error
+		if conns, err = p.grow(); err != nil {
+			return nil, err
+		}
+	}
+	return conns[(p.next.Add(1)-1)%uint64(len(conns))].ClientConn, nil
+}
+
+// Peek returns a dialed connection of the pool without dialing or counting it as used.
+func (p *Pool) Peek() (*grpc.ClientConn, bool) {
+	conns := *p.conns.Load()
+	if len(conns) == 0 {
+		return nil, false
+	}
+	return conns[0].ClientConn, true
+}
+
+// WaitReady connects the dialed connections and blocks until they are ready, or ctx is done.
+func (p *Pool) WaitReady(ctx context.Context) error {
+	for _, c := range *p.conns.Load() {
+		c.Connect()
+		for state := c.GetState(); state != connectivity.Ready; state = c.GetState() {
+			if state == connectivity.Shutdown {
+				return ErrPoolClosed
+			}
+			if !c.WaitForStateChange(ctx, state) {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// Evict removes all connections from the pool, each is closed once its calls in flight are finished,
+// the pool dials again on the next Get.
+func (p *Pool) Evict() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range *p.conns.Swap(&[]*conn{}) {
+		c.retire()
+	}
+}
+
+// Close closes all connections at once, the calls in flight are canceled.
+func (p *Pool) Close() error {
+	return p.shutdown((*conn).close)
+}
+
+// Drain closes the pool, unlike Close, each connection is closed once its calls in flight are finished.
+func (p *Pool) Drain() error {
+	return p.shutdown((*conn).retire)
+}
+
+func (p *Pool) shutdown(release func(*conn)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	p.closed = true
+	for _, c := range *p.conns.Swap(&[]*conn{}) {
+		release(c)
+	}
+	return nil
+}
+
+// New returns a pool of target, unless WithLazy is used, connections are dialed immediately
+// as long as the budget allows, the rest are dialed on demand.
+func New(ctx context.Context, target string, dialOpts []grpc.DialOption, opts ...Option) (*Pool, error) {
+	p := &Pool{
+		ctx:      ctx,
+		target:   target,
+		dialOpts: dialOpts,
+		size:     1,
+	}
+	p.conns.Store(&[]*conn{})
+	p.lastUsed.Store(time.Now().UnixNano())
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.lazy {
+		return p, nil
+	}
+	for i := 0; i < p.size; i++ {
+		_, err := p.grow()
+		if errors.Is(err, ErrPoolExhausted) {
+			break
+		}
+		if err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,323 @@
+package pool_test
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/pool"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const target = "127.0.0.1:1"
+
+var dialOpts = []grpc.DialOption{
+	grpc.WithTransportCredentials(insecure.NewCredentials()),
+}
+
+func TestPool_Eager(t *testing.T) {
+	p, err := pool.New(context.Background(), target, dialOpts, pool.WithSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if p.Len() != 4 {
+		t.Fatalf("eager pool should dial all connections, got: %d", p.Len())
+	}
+}
+
+func TestPool_Lazy(t *testing.T) {
+	p, err := pool.New(context.Background(), target, dialOpts, pool.WithSize(4), pool.WithLazy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if p.Len() != 0 {
+		t.Fatalf("lazy pool should not dial, got: %d", p.Len())
+	}
+
+	seen := make(map[*grpc.ClientConn]struct{})
+	for i := 0; i < 8; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[conn] = struct{}{}
+	}
+	if p.Len() != 4 || len(seen) != 4 {
+		t.Fatalf("unexpected connections, dialed: %d, used: %d", p.Len(), len(seen))
+	}
+}
+
+func TestPool_Budget(t *testing.T) {
+	var (
+		budget = pool.NewBudget(3)
+		pools  = make([]*pool.Pool, 3)
+		err    error
+	)
+	for i := range pools {
+		if pools[i], err = pool.New(context.Background(), target, dialOpts,
+			pool.WithSize(2), pool.WithLazy(), pool.WithBudget(budget)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err = pools[0].Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = pools[1].Get(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pools[2].Get(); !errors.Is(err, pool.ErrPoolExhausted) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if budget.Used() != 3 {
+		t.Fatalf("unexpected budget usage: %d", budget.Used())
+	}
+
+	pools[0].Evict()
+	if budget.Used() != 1 {
+		t.Fatalf("evicted connections should be returned to budget, usage: %d", budget.Used())
+	}
+	if _, err = pools[2].Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range pools {
+		_ = p.Close()
+	}
+	if budget.Used() != 0 {
+		t.Fatalf("closed pools leaked budget: %d", budget.Used())
+	}
+}
+
+func TestPool_Idle(t *testing.T) {
+	p, err := pool.New(context.Background(), target, dialOpts, pool.WithLazy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if p.Idle(0) {
+		t.Fatal("empty pool should not be idle")
+	}
+	if _, err = p.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Idle(time.Hour) {
+		t.Fatal("pool has just been used")
+	}
+	time.Sleep(time.Millisecond * 10)
+	if !p.Idle(time.Millisecond * 5) {
+		t.Fatal("pool should be idle")
+	}
+}
+
+// streamServer holds every stream open until release is closed.
+func streamServer(t *testing.T, release <-chan struct{}) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(any, grpc.ServerStream) error {
+		<-release
+		return nil
+	}))
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestPool_EvictInFlight(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		budget  = pool.NewBudget(1)
+	)
+	p, err := pool.New(context.Background(), streamServer(t, release), dialOpts, pool.WithBudget(budget))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/pool.Test/Stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond * 10)
+	if p.Idle(time.Millisecond) {
+		t.Fatal("pool with a stream in flight should not be idle")
+	}
+
+	// the connection of the stream is closed once the stream is finished
+	p.Evict()
+	if p.Len() != 0 {
+		t.Fatalf("evicted pool still holds connections: %d", p.Len())
+	}
+	if state := conn.GetState(); state == connectivity.Shutdown {
+		t.Fatal("the connection of a stream in flight was closed")
+	}
+	if budget.Used() != 1 {
+		t.Fatalf("the connection in use was returned to budget, usage: %d", budget.Used())
+	}
+
+	close(release)
+	if err = stream.RecvMsg(nil); !errors.Is(err, io.EOF) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state := conn.GetState(); state != connectivity.Shutdown {
+		t.Fatalf("the evicted connection wasn't closed, state: %s", state)
+	}
+	if budget.Used() != 0 {
+		t.Fatalf("the closed connection wasn't returned to budget, usage: %d", budget.Used())
+	}
+}
+
+func TestPool_Drain(t *testing.T) {
+	release := make(chan struct{})
+	p, err := pool.New(context.Background(), streamServer(t, release), dialOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/pool.Test/Stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Get(); !errors.Is(err, pool.ErrPoolClosed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = p.Close(); !errors.Is(err, pool.ErrPoolClosed) {
+		t.Fatalf("a drained pool was closed again: %v", err)
+	}
+	if state := conn.GetState(); state == connectivity.Shutdown {
+		t.Fatal("the connection of a stream in flight was closed")
+	}
+
+	close(release)
+	if err = stream.RecvMsg(nil); !errors.Is(err, io.EOF) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state := conn.GetState(); state != connectivity.Shutdown {
+		t.Fatalf("the drained connection wasn't closed, state: %s", state)
+	}
+}
+
+func TestPool_WaitReady(t *testing.T) {
+	p, err := pool.New(context.Background(), streamServer(t, nil), dialOpts, pool.WithSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = p.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	unreachable, err := pool.New(context.Background(), target, dialOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unreachable.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = unreachable.WaitReady(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPool_Close(t *testing.T) {
+	p, err := pool.New(context.Background(), target, dialOpts, pool.WithSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Get(); !errors.Is(err, pool.ErrPoolClosed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = p.Close(); !errors.Is(err, pool.ErrPoolClosed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPool_Concurrent(t *testing.T) {
+	var (
+		budget = pool.NewBudget(8)
+		wg     sync.WaitGroup
+	)
+	p, err := pool.New(context.Background(), target, dialOpts,
+		pool.WithSize(8), pool.WithLazy(), pool.WithBudget(budget))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if (i+j)%50 == 0 {
+					p.Evict()
+					continue
+				}
+				if _, err := p.Get(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if p.Len() > 8 || budget.Used() != p.Len() {
+		t.Fatalf("inconsistent pool, connections: %d, budget: %d", p.Len(), budget.Used())
+	}
+	_ = p.Close()
+}
+
+func TestPool_DialTimeout(t *testing.T) {
+	var (
+		start  = time.Now()
+		_, err = pool.New(
+			context.Background(),
+			target,
+			append([]grpc.DialOption{grpc.WithBlock()}, dialOpts...),
+			pool.WithDialTimeout(100*time.Millisecond),
+		)
+	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("blocking dial to an unreachable target should time out, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("dial timeout not honoured, took: %s", elapsed)
+	}
+}
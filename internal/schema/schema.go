@@ -0,0 +1,174 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+)
+
+// Type of a property, the names follow JSON schema.
+type Type string
+
+const (
+	Any     Type = ""
+	String  Type = "string"
+	Number  Type = "number"
+	Integer Type = "integer"
+	Boolean Type = "boolean"
+	Array   Type = "array"
+	Object  Type = "object"
+)
+
+// Property constrains a value, the zero value accepts anything.
+type Property struct {
+	Type Type
+
+	// Enum lists the allowed values.
+	Enum []any
+
+	// Minimum and Maximum bound a number.
+	Minimum, Maximum *float64
+
+	// Pattern is a regular expression a string must match.
+	Pattern string
+
+	// Items constrains the elements of an array.
+	Items *Property
+}
+
+// Schema constrains a JSON object decoded into map[string]any.
+type Schema struct {
+	Required   []string
+	Properties map[string]Property
+
+	// AdditionalProperties allows the properties which are not declared in Properties.
+	AdditionalProperties bool
+}
+
+// Error is a validation failure of a field.
+type Error struct {
+	Field  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("field %q %s", e.Field, e.Reason)
+}
+
+func (s *Schema) Validate(doc map[string]any) error {
+	for _, field := range s.Required {
+		if _, ok := doc[field]; !ok {
+			return &Error{Field: field, Reason: "is required"}
+		}
+	}
+
+	// validate in a stable order, the same document always reports the same error
+	fields := make([]string, 0, len(doc))
+	for field := range doc {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	for _, field := range fields {
+		p, ok := s.Properties[field]
+		if !ok {
+			if !s.AdditionalProperties {
+				return &Error{Field: field, Reason: "is not allowed"}
+			}
+			continue
+		}
+		if reason := p.validate(doc[field]); reason != "" {
+			return &Error{Field: field, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// validate returns the reason of the failure, or an empty string.
+func (p *Property) validate(v any) string {
+	if reason := p.validateType(v); reason != "" {
+		return reason
+	}
+
+	if len(p.Enum) != 0 && !slices.ContainsFunc(p.Enum, func(e any) bool { return equal(e, v) }) {
+		return fmt.Sprintf("must be one of %v", p.Enum)
+	}
+
+	switch value := v.(type) {
+	case float64:
+		if p.Minimum != nil && value < *p.Minimum {
+			return fmt.Sprintf("must be >= %v", *p.Minimum)
+		}
+		if p.Maximum != nil && value > *p.Maximum {
+			return fmt.Sprintf("must be <= %v", *p.Maximum)
+		}
+	case string:
+		if p.Pattern == "" {
+			break
+		}
+		matched, err := regexp.MatchString(p.Pattern, value)
+		if err != nil {
+			return fmt.Sprintf("has an invalid pattern: %s", err)
+		}
+		if !matched {
+			return fmt.Sprintf("must match %q", p.Pattern)
+		}
+	case []any:
+		if p.Items == nil {
+			break
+		}
+		for i, item := range value {
+			if reason := p.Items.validate(item); reason != "" {
+				return fmt.Sprintf("item %d %s", i, reason)
+			}
+		}
+	}
+	return ""
+}
+
+func (p *Property) validateType(v any) string {
+	var ok bool
+	switch p.Type {
+	case Any:
+		return ""
+	case String:
+		_, ok = v.(string)
+	case Number:
+		_, ok = v.(float64)
+	case Integer:
+		var n float64
+		n, ok = v.(float64)
+		ok = ok && n == math.Trunc(n)
+	case Boolean:
+		_, ok = v.(bool)
+	case Array:
+		_, ok = v.([]any)
+	case Object:
+		_, ok = v.(map[string]any)
+	default:
+		return fmt.Sprintf("has an unknown type %q", p.Type)
+	}
+	if !ok {
+		return "must be " + string(p.Type)
+	}
+	return ""
+}
+
+// equal compares a decoded value with an enum value, numbers of any kind are compared as float64.
+func equal(e, v any) bool {
+	if n, ok := v.(float64); ok {
+		rv := reflect.ValueOf(e)
+		switch {
+		case rv.CanInt():
+			return float64(rv.Int()) == n
+		case rv.CanUint():
+			return float64(rv.Uint()) == n
+		case rv.CanFloat():
+			return rv.Float() == n
+		}
+		return false
+	}
+	return reflect.DeepEqual(e, v)
+}
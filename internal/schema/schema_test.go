@@ -0,0 +1,73 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"github.com/RealFax/red-discovery/internal/schema"
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+var metadataSchema = schema.Schema{
+	Required: []string{"zone", "weight"},
+	Properties: map[string]schema.Property{
+		"zone":     {Type: schema.String, Pattern: `^[a-z]+-[a-z]+-\d$`},
+		"weight":   {Type: schema.Integer, Minimum: ptr(0), Maximum: ptr(100)},
+		"protocol": {Type: schema.String, Enum: []any{"grpc", "http"}},
+		"tags":     {Type: schema.Array, Items: &schema.Property{Type: schema.String}},
+		"canary":   {Type: schema.Boolean},
+		"shard":    {Enum: []any{1, 2, 3}},
+	},
+}
+
+func decode(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestSchema_Valid(t *testing.T) {
+	doc := decode(t, `{"zone":"us-east-1","weight":10,"protocol":"grpc","tags":["a","b"],"canary":true,"shard":2}`)
+	if err := metadataSchema.Validate(doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchema_Invalid(t *testing.T) {
+	tests := map[string]string{
+		`{"weight":10}`:                                  "zone",
+		`{"zone":"us-east-1","weight":10.5}`:             "weight",
+		`{"zone":"us-east-1","weight":101}`:              "weight",
+		`{"zone":"us-east-1","weight":-1}`:               "weight",
+		`{"zone":"useast","weight":10}`:                  "zone",
+		`{"zone":1,"weight":10}`:                         "zone",
+		`{"zone":"us-east-1","weight":1,"protocol":"x"}`: "protocol",
+		`{"zone":"us-east-1","weight":1,"tags":["a",1]}`: "tags",
+		`{"zone":"us-east-1","weight":1,"canary":"yes"}`: "canary",
+		`{"zone":"us-east-1","weight":1,"shard":4}`:      "shard",
+		`{"zone":"us-east-1","weight":1,"owner":"x"}`:    "owner",
+	}
+	for doc, field := range tests {
+		err := metadataSchema.Validate(decode(t, doc))
+		var schemaErr *schema.Error
+		if !errors.As(err, &schemaErr) {
+			t.Fatalf("%s should be invalid, got: %v", doc, err)
+		}
+		if schemaErr.Field != field {
+			t.Errorf("%s should fail on %q, got: %v", doc, field, err)
+		}
+	}
+}
+
+func TestSchema_AdditionalProperties(t *testing.T) {
+	s := schema.Schema{AdditionalProperties: true}
+	if err := s.Validate(decode(t, `{"anything":{"nested":[1,2]}}`)); err != nil {
+		t.Fatal(err)
+	}
+}
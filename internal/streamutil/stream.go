@@ -0,0 +1,51 @@
+package streamutil
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+)
+
+// doneStream calls done with the final status of a stream once it is finished.
+type doneStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	once sync.Once
+	stop func() bool
+	done func(err error)
+}
+
+// OnFinish calls done with the final status of stream once it is finished,
+// i.e. the last message is received, the stream fails or ctx of the stream is done.
+func OnFinish(ctx context.Context, desc *grpc.StreamDesc, stream grpc.ClientStream, done func(err error)) grpc.ClientStream {
+	s := &doneStream{ClientStream: stream, desc: desc, done: done}
+	// the stream abandoned by the caller is finished by canceling its ctx
+	s.stop = context.AfterFunc(ctx, func() {
+		s.finish(status.FromContextError(ctx.Err()).Err())
+	})
+	return s
+}
+
+func (s *doneStream) finish(err error) {
+	s.once.Do(func() {
+		s.stop()
+		s.done(err)
+	})
+}
+
+func (s *doneStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case errors.Is(err, io.EOF):
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.desc.ServerStreams:
+		// the single response of a client stream is the last message
+		s.finish(nil)
+	}
+	return err
+}
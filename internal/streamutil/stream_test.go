@@ -0,0 +1,96 @@
+package streamutil_test
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/streamutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"testing"
+	"time"
+)
+
+// stream returns the errors of RecvMsg in order.
+type stream struct {
+	grpc.ClientStream
+	errs []error
+}
+
+func (s *stream) RecvMsg(any) error {
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+type result struct {
+	calls int
+	err   error
+}
+
+func (r *result) done(err error) {
+	r.calls++
+	r.err = err
+}
+
+func TestOnFinish_LastMessage(t *testing.T) {
+	var (
+		r result
+		s = streamutil.OnFinish(context.Background(), &grpc.StreamDesc{ServerStreams: true},
+			&stream{errs: []error{nil, nil, io.EOF}}, r.done)
+	)
+	for i := 0; i < 2; i++ {
+		_ = s.RecvMsg(nil)
+		if r.calls != 0 {
+			t.Fatalf("stream finished by message %d", i)
+		}
+	}
+	_ = s.RecvMsg(nil)
+	if r.calls != 1 || r.err != nil {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+}
+
+func TestOnFinish_ClientStream(t *testing.T) {
+	var (
+		r result
+		s = streamutil.OnFinish(context.Background(), &grpc.StreamDesc{ClientStreams: true},
+			&stream{errs: []error{nil}}, r.done)
+	)
+	_ = s.RecvMsg(nil)
+	if r.calls != 1 || r.err != nil {
+		t.Fatalf("the single response didn't finish the stream: %+v", r)
+	}
+}
+
+func TestOnFinish_Failed(t *testing.T) {
+	var (
+		r    result
+		fail = status.Error(codes.Unavailable, "unavailable")
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := streamutil.OnFinish(ctx, &grpc.StreamDesc{ServerStreams: true}, &stream{errs: []error{fail}}, r.done)
+	_ = s.RecvMsg(nil)
+	cancel()
+	if r.calls != 1 || r.err != fail {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+}
+
+func TestOnFinish_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	streamutil.OnFinish(ctx, &grpc.StreamDesc{ServerStreams: true}, &stream{}, func(err error) {
+		errs <- err
+	})
+
+	cancel()
+	select {
+	case err := <-errs:
+		if status.Code(err) != codes.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the abandoned stream wasn't finished by its ctx")
+	}
+}
@@ -0,0 +1,59 @@
+package subset
+
+import (
+	"hash/fnv"
+	"slices"
+)
+
+func score(clientID, key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(clientID))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(key))
+	return mix(h.Sum64())
+}
+
+// mix is the finalizer of murmur3, it spreads the fnv hash over all bits.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Rendezvous selects size keys with the highest rendezvous hash scores for clientID,
+// a key joining or leaving only changes the subset if it is, or enters, the top size keys.
+func Rendezvous(clientID string, keys []string, size int) []string {
+	if size <= 0 || size >= len(keys) {
+		return slices.Clone(keys)
+	}
+
+	type scored struct {
+		key   string
+		score uint64
+	}
+	s := make([]scored, len(keys))
+	for i, key := range keys {
+		s[i] = scored{key: key, score: score(clientID, key)}
+	}
+	slices.SortFunc(s, func(a, b scored) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		if a.key < b.key {
+			return -1
+		}
+		return 1
+	})
+
+	subset := make([]string, size)
+	for i := range subset {
+		subset[i] = s[i].key
+	}
+	return subset
+}
@@ -0,0 +1,128 @@
+package subset_test
+
+import (
+	"github.com/RealFax/red-discovery/internal/subset"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func keys(n int) []string {
+	k := make([]string, n)
+	for i := range k {
+		k[i] = "endpoint-" + strconv.Itoa(i)
+	}
+	return k
+}
+
+func TestRendezvous_Stable(t *testing.T) {
+	var (
+		k        = keys(100)
+		a        = subset.Rendezvous("client-1", k, 10)
+		shuffled = slices.Clone(k)
+	)
+	slices.Reverse(shuffled)
+	b := subset.Rendezvous("client-1", shuffled, 10)
+
+	slices.Sort(a)
+	slices.Sort(b)
+	if !slices.Equal(a, b) {
+		t.Fatalf("subset depends on the order of keys, %v != %v", a, b)
+	}
+	if len(a) != 10 {
+		t.Fatalf("unexpected subset size: %d", len(a))
+	}
+}
+
+func TestRendezvous_Small(t *testing.T) {
+	if s := subset.Rendezvous("client-1", keys(3), 10); len(s) != 3 {
+		t.Fatalf("subset larger than keys should return all keys, got: %d", len(s))
+	}
+	if s := subset.Rendezvous("client-1", keys(3), 0); len(s) != 3 {
+		t.Fatalf("zero size should return all keys, got: %d", len(s))
+	}
+}
+
+func diff(a, b []string) int {
+	n := 0
+	for _, key := range a {
+		if !slices.Contains(b, key) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRendezvous_Join(t *testing.T) {
+	k := keys(200)
+	for i := 0; i < 50; i++ {
+		var (
+			clientID = "client-" + strconv.Itoa(i)
+			before   = subset.Rendezvous(clientID, k, 10)
+			after    = subset.Rendezvous(clientID, append(slices.Clone(k), "endpoint-new"), 10)
+		)
+		if n := diff(before, after); n > 1 {
+			t.Fatalf("a joining endpoint should replace at most one member, replaced: %d", n)
+		}
+		if n := diff(before, after); n == 1 && !slices.Contains(after, "endpoint-new") {
+			t.Fatal("only the joining endpoint may enter the subset")
+		}
+	}
+}
+
+func TestRendezvous_Leave(t *testing.T) {
+	k := keys(200)
+	for i := 0; i < 50; i++ {
+		var (
+			clientID = "client-" + strconv.Itoa(i)
+			before   = subset.Rendezvous(clientID, k, 10)
+		)
+
+		// an endpoint out of the subset leaves
+		outside := slices.DeleteFunc(slices.Clone(k), func(key string) bool {
+			return slices.Contains(before, key)
+		})[0]
+		after := subset.Rendezvous(clientID, slices.DeleteFunc(slices.Clone(k), func(key string) bool {
+			return key == outside
+		}), 10)
+		if n := diff(before, after); n != 0 {
+			t.Fatalf("a leaving endpoint out of the subset should not change the subset, changed: %d", n)
+		}
+
+		// a member leaves
+		after = subset.Rendezvous(clientID, slices.DeleteFunc(slices.Clone(k), func(key string) bool {
+			return key == before[0]
+		}), 10)
+		if n := diff(before, after); n != 1 {
+			t.Fatalf("a leaving member should be replaced by exactly one endpoint, replaced: %d", n)
+		}
+	}
+}
+
+func TestRendezvous_Balance(t *testing.T) {
+	const (
+		clients   = 2000
+		endpoints = 100
+		size      = 10
+	)
+	var (
+		k     = keys(endpoints)
+		conns = make(map[string]int, endpoints)
+	)
+	for i := 0; i < clients; i++ {
+		for _, key := range subset.Rendezvous("client-"+strconv.Itoa(i), k, size) {
+			conns[key]++
+		}
+	}
+
+	// every endpoint expects clients*size/endpoints connections
+	expected := clients * size / endpoints
+	for key, n := range conns {
+		if n < expected/2 || n > expected*3/2 {
+			t.Fatalf("endpoint %s has %d clients, expected about %d", key, n, expected)
+		}
+	}
+	if len(conns) != endpoints {
+		t.Fatalf("%d endpoints without any client", endpoints-len(conns))
+	}
+}
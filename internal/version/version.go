@@ -0,0 +1,246 @@
+package version
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidVersion    = errors.New("invalid version")
+	ErrInvalidConstraint = errors.New("invalid version constraint")
+)
+
+// Version is a semantic version, a partial version omits the trailing components.
+type Version struct {
+	Major, Minor, Patch uint64
+
+	// Pre is the pre-release of a complete version, e.g. "rc.1" of v2.1.0-rc.1.
+	Pre string
+
+	// parts is the number of given components.
+	parts int
+}
+
+func (v Version) String() string {
+	s := "v" + strconv.FormatUint(v.Major, 10)
+	if v.parts > 1 {
+		s += "." + strconv.FormatUint(v.Minor, 10)
+	}
+	if v.parts > 2 {
+		s += "." + strconv.FormatUint(v.Patch, 10)
+	}
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1, the omitted components are regarded as 0.
+// A pre-release precedes its version, e.g. v2.1.0-rc.1 < v2.1.0.
+func (v Version) Compare(o Version) int {
+	for _, c := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		switch {
+		case c[0] < c[1]:
+			return -1
+		case c[0] > c[1]:
+			return 1
+		}
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+// comparePre compares the pre-releases by their dot separated identifiers, numeric identifiers
+// precede the alphanumeric ones, and a version without pre-release follows all of its pre-releases.
+func comparePre(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aerr == nil && berr != nil:
+			return -1
+		case aerr != nil && berr == nil:
+			return 1
+		case aerr != nil && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// prefixOf reports whether the given components of v equal to o, e.g. v2.1 is a prefix of v2.1.3.
+// A partial version is also a prefix of the pre-releases, a complete one only of the same pre-release.
+func (v Version) prefixOf(o Version) bool {
+	return o.Major == v.Major &&
+		(v.parts < 2 || o.Minor == v.Minor) &&
+		(v.parts < 3 || (o.Patch == v.Patch && o.Pre == v.Pre))
+}
+
+// validPre reports whether pre is a dot separated list of non-empty identifiers of
+// letters, digits and '-', a numeric identifier has no leading zero.
+func validPre(pre string) bool {
+	for _, ident := range strings.Split(pre, ".") {
+		if ident == "" {
+			return false
+		}
+		numeric := true
+		for _, r := range ident {
+			switch {
+			case r >= '0' && r <= '9':
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '-':
+				numeric = false
+			default:
+				return false
+			}
+		}
+		if numeric && len(ident) > 1 && ident[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse parses "v2", "2.1", "v2.1.3" or "v2.1.3-rc.1", build metadata after '+' is ignored.
+// Only a complete version has a pre-release.
+func Parse(s string) (Version, error) {
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	s, pre, hasPre := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || (hasPre && (len(parts) != 3 || !validPre(pre))) {
+		return Version{}, ErrInvalidVersion
+	}
+
+	var (
+		v   = Version{Pre: pre, parts: len(parts)}
+		dst = []*uint64{&v.Major, &v.Minor, &v.Patch}
+	)
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, ErrInvalidVersion
+		}
+		*dst[i] = n
+	}
+	return v, nil
+}
+
+type op int
+
+const (
+	opPrefix op = iota
+	opNotPrefix
+	opGreater
+	opGreaterEqual
+	opLess
+	opLessEqual
+	opTilde
+	opCaret
+)
+
+var ops = []struct {
+	token string
+	op    op
+}{
+	// longer tokens first
+	{">=", opGreaterEqual},
+	{"<=", opLessEqual},
+	{"!=", opNotPrefix},
+	{">", opGreater},
+	{"<", opLess},
+	{"=", opPrefix},
+	{"~", opTilde},
+	{"^", opCaret},
+}
+
+type term struct {
+	op op
+	v  Version
+}
+
+func (t term) match(v Version) bool {
+	switch t.op {
+	case opNotPrefix:
+		return !t.v.prefixOf(v)
+	case opGreater:
+		return v.Compare(t.v) > 0
+	case opGreaterEqual:
+		return v.Compare(t.v) >= 0
+	case opLess:
+		return v.Compare(t.v) < 0
+	case opLessEqual:
+		return v.Compare(t.v) <= 0
+	case opTilde:
+		if t.v.parts < 2 {
+			return v.Compare(t.v) >= 0 && v.Major == t.v.Major
+		}
+		return v.Compare(t.v) >= 0 && v.Major == t.v.Major && v.Minor == t.v.Minor
+	case opCaret:
+		return v.Compare(t.v) >= 0 && v.Major == t.v.Major
+	default:
+		return t.v.prefixOf(v)
+	}
+}
+
+// Constraint is a comma separated list of terms which must all match, e.g. ">=2.1, <3".
+//
+// A version without operator, or with '=', matches the versions it is a prefix of, e.g. "v2" matches v2.4.1,
+// '!=' negates it. '~' allows patch updates and '^' allows minor updates.
+// Comparisons regard the omitted components as 0.
+type Constraint []term
+
+func (c Constraint) Match(v Version) bool {
+	for _, t := range c {
+		if !t.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchString parses v and matches it, an invalid version never matches.
+func (c Constraint) MatchString(v string) bool {
+	parsed, err := Parse(v)
+	return err == nil && c.Match(parsed)
+}
+
+func ParseConstraint(s string) (Constraint, error) {
+	var c Constraint
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		t := term{op: opPrefix}
+		for _, o := range ops {
+			if rest, ok := strings.CutPrefix(raw, o.token); ok {
+				t.op, raw = o.op, strings.TrimSpace(rest)
+				break
+			}
+		}
+
+		var err error
+		if t.v, err = Parse(raw); err != nil {
+			return nil, ErrInvalidConstraint
+		}
+		c = append(c, t)
+	}
+	return c, nil
+}
@@ -0,0 +1,86 @@
+package version_test
+
+import (
+	"github.com/RealFax/red-discovery/internal/version"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]string{
+		"2":         "v2",
+		"v2.1":      "v2.1",
+		"v2.1.3":    "v2.1.3",
+		"2.1.3+b42": "v2.1.3",
+		"v10.0.0":   "v10.0.0",
+
+		"v2.1.0-rc1":        "v2.1.0-rc1",
+		"2.1.0-rc.1+b42":    "v2.1.0-rc.1",
+		"v2.1.0-alpha-beta": "v2.1.0-alpha-beta",
+	}
+	for s, expected := range tests {
+		v, err := version.Parse(s)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", s, err)
+		}
+		if v.String() != expected {
+			t.Errorf("Parse(%q) = %s, expected %s", s, v, expected)
+		}
+	}
+
+	for _, s := range []string{"", "v", "2.x", "1.2.3.4", "-1", "v2.1-rc1", "v2.1.0-", "v2.1.0-rc..1", "v2.1.0-01", "v2.1.0-rc_1"} {
+		if _, err := version.Parse(s); err == nil {
+			t.Errorf("Parse(%q) should fail", s)
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		mismatches []string
+	}{
+		{"v2", []string{"2.0.0", "v2.4.1"}, []string{"v1.9.9", "v3.0.0", "v20.0.0"}},
+		{"=2.1", []string{"v2.1.0", "v2.1.9"}, []string{"v2.2.0", "v2.0.9"}},
+		{"!=2.1", []string{"v2.2.0", "v1.1.0"}, []string{"v2.1.5"}},
+		{">=2.1", []string{"v2.1.0", "v2.3.0", "v3.0.0"}, []string{"v2.0.9", "v1.9.0"}},
+		{">2.1", []string{"v2.1.1", "v3"}, []string{"v2.1.0", "v2.0.0"}},
+		{"<2", []string{"v1.9.9"}, []string{"v2.0.0", "v2.0.1"}},
+		{"<=2.1", []string{"v2.1.0", "v1.0.0"}, []string{"v2.1.1"}},
+		{"~2.1.3", []string{"v2.1.3", "v2.1.9"}, []string{"v2.1.2", "v2.2.0"}},
+		{"~2", []string{"v2.0.0", "v2.9.0"}, []string{"v3.0.0", "v1.0.0"}},
+		{"^2.1", []string{"v2.1.0", "v2.9.9"}, []string{"v2.0.9", "v3.0.0"}},
+		{">=2.1, <3", []string{"v2.1.0", "v2.9.0"}, []string{"v3.0.0", "v2.0.0"}},
+		{">=2.1", []string{"v2.1.1-rc1"}, []string{"v2.1.0-rc1"}},
+		{">=2.1.0-rc.2", []string{"v2.1.0-rc.10", "v2.1.0-rc2", "v2.1.0"}, []string{"v2.1.0-rc.1", "v2.1.0-beta", "v2.1.0-rc"}},
+		{"<2.1.0-rc1", []string{"v2.1.0-1", "v2.0.9"}, []string{"v2.1.0-rc1", "v2.1.0"}},
+		{"v2.1", []string{"v2.1.0-rc1", "v2.1.3"}, []string{"v2.2.0-rc1"}},
+		{"=2.1.0", []string{"v2.1.0"}, []string{"v2.1.0-rc1"}},
+		{"=2.1.0-rc1", []string{"v2.1.0-rc1"}, []string{"v2.1.0", "v2.1.0-rc2"}},
+	}
+	for _, tt := range tests {
+		c, err := version.ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%q): %v", tt.constraint, err)
+		}
+		for _, v := range tt.matches {
+			if !c.MatchString(v) {
+				t.Errorf("%q should match %s", tt.constraint, v)
+			}
+		}
+		for _, v := range tt.mismatches {
+			if c.MatchString(v) {
+				t.Errorf("%q should not match %s", tt.constraint, v)
+			}
+		}
+	}
+
+	if c, _ := version.ParseConstraint("v2"); c.MatchString("invalid") {
+		t.Error("an invalid version should never match")
+	}
+	for _, s := range []string{"", ">=", ">=2.1,", "=>2"} {
+		if _, err := version.ParseConstraint(s); err == nil {
+			t.Errorf("ParseConstraint(%q) should fail", s)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/streamutil"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const (
+	DefaultInvokeMaxAttempts = 3
+)
+
+var (
+	DefaultRetryableCodes = []codes.Code{
+		codes.Unavailable,
+	}
+)
+
+type InvokeOption func(*invokeOptions)
+
+type invokeOptions struct {
+	timeout         time.Duration
+	maxAttempts     int
+	retryableCodes  map[codes.Code]struct{}
+	callOpts        []grpc.CallOption
+	hedgeDelay      time.Duration
+	hedgePercentile float64
+	hedgeRatio      float64
+}
+
+func (o *invokeOptions) retryable(err error) bool {
+	_, ok := o.retryableCodes[status.Code(err)]
+	return ok
+}
+
+// WithInvokeTimeout set the deadline of the whole call, including all retries.
+func WithInvokeTimeout(timeout time.Duration) InvokeOption {
+	return func(o *invokeOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithMaxAttempts set the maximum number of attempts, each attempt uses a different endpoint.
+func WithMaxAttempts(attempts int) InvokeOption {
+	return func(o *invokeOptions) {
+		if attempts > 0 {
+			o.maxAttempts = attempts
+		}
+	}
+}
+
+// WithRetryableCodes replace the grpc codes which will be retried, default is DefaultRetryableCodes.
+func WithRetryableCodes(c ...codes.Code) InvokeOption {
+	return func(o *invokeOptions) {
+		o.retryableCodes = make(map[codes.Code]struct{}, len(c))
+		for _, code := range c {
+			o.retryableCodes[code] = struct{}{}
+		}
+	}
+}
+
+// WithCallOptions append grpc call options to every attempt.
+func WithCallOptions(opts ...grpc.CallOption) InvokeOption {
+	return func(o *invokeOptions) {
+		o.callOpts = append(o.callOpts, opts...)
+	}
+}
+
+func newInvokeOptions(opts ...InvokeOption) *invokeOptions {
+	o := &invokeOptions{
+		maxAttempts: DefaultInvokeMaxAttempts,
+	}
+	WithRetryableCodes(DefaultRetryableCodes...)(o)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (s *service) Invoke(ctx context.Context, method string, req, resp any, opts ...InvokeOption) error {
+	o := newInvokeOptions(opts...)
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	l := s.limiter.Load()
+	if err := l.acquire(ctx); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	defer l.release()
+
+	hedging := o.hedging()
+	if hedging {
+		s.hedge.deposit(o.hedgeRatio)
+	}
+
+	var (
+		err   error
+		tried = make(map[string]struct{}, o.maxAttempts)
+	)
+	for attempt := 0; attempt < o.maxAttempts; attempt++ {
+		var attemptErr error
+		if hedging {
+			attemptErr = s.invokeHedged(ctx, method, req, resp, o, tried)
+		} else {
+			attemptErr = s.invoke(ctx, method, req, resp, o, tried)
+		}
+
+		// every endpoint has been tried, returns the last failure
+		if errors.Is(attemptErr, ErrServiceUnreachable) && err != nil {
+			return err
+		}
+		err = attemptErr
+		if err == nil || !o.retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// invoke performs a single attempt, the endpoint used by this attempt is added to tried.
+func (s *service) invoke(ctx context.Context, method string, req, resp any, o *invokeOptions, tried map[string]struct{}) error {
+	endpoint, conn, reserved, err := s.pick(tried, true)
+	if err != nil {
+		return err
+	}
+	tried[endpoint.ID] = struct{}{}
+
+	err = conn.Invoke(ctx, method, req, resp, o.callOpts...)
+	reserved.release()
+	return err
+}
+
+func (s *service) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...InvokeOption) (grpc.ClientStream, error) {
+	o := newInvokeOptions(opts...)
+
+	cancel := context.CancelFunc(func() {})
+	if o.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+	}
+
+	l := s.limiter.Load()
+	if err := l.acquire(ctx); err != nil {
+		cancel()
+		return nil, status.FromContextError(err).Err()
+	}
+
+	var (
+		err    error
+		stream grpc.ClientStream
+		tried  = make(map[string]struct{}, o.maxAttempts)
+	)
+	for attempt := 0; attempt < o.maxAttempts; attempt++ {
+		endpoint, conn, reserved, pickErr := s.pick(tried, true)
+		if pickErr != nil {
+			if err == nil {
+				err = pickErr
+			}
+			break
+		}
+		tried[endpoint.ID] = struct{}{}
+
+		stream, err = conn.NewStream(ctx, desc, method, o.callOpts...)
+		if err == nil {
+			return streamutil.OnFinish(ctx, desc, stream, func(error) {
+				cancel()
+				reserved.release()
+				l.release()
+			}), nil
+		}
+		reserved.release()
+		if !o.retryable(err) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	cancel()
+	l.release()
+	return nil, err
+}
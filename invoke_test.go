@@ -0,0 +1,131 @@
+package discovery
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"testing"
+	"time"
+)
+
+func TestService_InvokeFailover(t *testing.T) {
+	var (
+		down = newTestServer(t, fail(codes.Unavailable))
+		up   = newTestServer(t, reply)
+		srv  = newTestService(t, []*testServer{down, up})
+	)
+
+	for i := 0; i < 10; i++ {
+		if err := invokeTest(srv, WithMaxAttempts(2)); err != nil {
+			t.Fatalf("call %d not failed over: %v", i, err)
+		}
+	}
+	if down.calls.Load() == 0 {
+		t.Fatal("the unavailable endpoint was never picked")
+	}
+	if up.calls.Load() != 10 {
+		t.Fatalf("unexpected calls of the available endpoint: %d", up.calls.Load())
+	}
+}
+
+func TestService_InvokeNotRetryable(t *testing.T) {
+	var (
+		a   = newTestServer(t, fail(codes.InvalidArgument))
+		b   = newTestServer(t, fail(codes.InvalidArgument))
+		srv = newTestService(t, []*testServer{a, b})
+	)
+
+	if err := invokeTest(srv, WithMaxAttempts(3)); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := a.calls.Load() + b.calls.Load(); calls != 1 {
+		t.Fatalf("a non-retryable error was retried, calls: %d", calls)
+	}
+
+	// retried once the code is retryable
+	if err := invokeTest(srv, WithRetryableCodes(codes.InvalidArgument)); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := a.calls.Load() + b.calls.Load(); calls != 3 {
+		t.Fatalf("unexpected calls: %d", calls)
+	}
+}
+
+func TestService_InvokeAllFailed(t *testing.T) {
+	var (
+		a   = newTestServer(t, fail(codes.Unavailable))
+		b   = newTestServer(t, fail(codes.Unavailable))
+		srv = newTestService(t, []*testServer{a, b})
+	)
+
+	// every endpoint is tried once, the last failure is returned instead of ErrServiceUnreachable
+	if err := invokeTest(srv, WithMaxAttempts(5)); status.Code(err) != codes.Unavailable {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.calls.Load() != 1 || b.calls.Load() != 1 {
+		t.Fatalf("unexpected calls: %d, %d", a.calls.Load(), b.calls.Load())
+	}
+}
+
+func TestService_InvokeTimeout(t *testing.T) {
+	srv := newTestService(t, []*testServer{newTestServer(t, delay(time.Second))})
+
+	start := time.Now()
+	if err := invokeTest(srv, WithInvokeTimeout(50*time.Millisecond)); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("the timeout wasn't applied, elapsed: %s", elapsed)
+	}
+}
+
+func TestService_NewStreamFinished(t *testing.T) {
+	ts := newTestServer(t, reply)
+	srv := newTestService(t, []*testServer{ts}, WithLimits(Limits{MaxConcurrent: 1}))
+
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	for i := 0; i < 3; i++ {
+		stream, err := srv.NewStream(context.Background(), desc, testMethod, WithInvokeTimeout(time.Second))
+		if err != nil {
+			t.Fatalf("stream %d: %v", i, err)
+		}
+		if err = stream.SendMsg(&emptypb.Empty{}); err != nil {
+			t.Fatal(err)
+		}
+		_ = stream.CloseSend()
+		if err = stream.RecvMsg(&emptypb.Empty{}); err != nil {
+			t.Fatal(err)
+		}
+		// the stream is finished by the last message, it releases the concurrency slot
+		if err = stream.RecvMsg(&emptypb.Empty{}); err == nil {
+			t.Fatal("expected the end of the stream")
+		}
+	}
+}
+
+func TestService_NewStreamAbandoned(t *testing.T) {
+	ts := newTestServer(t, delay(time.Minute))
+	srv := newTestService(t, []*testServer{ts}, WithLimits(Limits{MaxConcurrent: 1}))
+
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := srv.NewStream(ctx, desc, testMethod); err != nil {
+		t.Fatal(err)
+	}
+
+	full, fullCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer fullCancel()
+	if _, err := srv.NewStream(full, desc, testMethod); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("the limit wasn't held by the open stream: %v", err)
+	}
+
+	// the abandoned stream is finished by its ctx
+	cancel()
+	next, nextCancel := context.WithTimeout(context.Background(), time.Second)
+	defer nextCancel()
+	if _, err := srv.NewStream(next, desc, testMethod); err != nil {
+		t.Fatalf("the slot of the canceled stream wasn't released: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package discovery
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/backoff"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	// DefaultKeepAliveJitter randomizes the keepalive interval by up to ±20%,
+	// the refreshes of a fleet restarted together spread out.
+	DefaultKeepAliveJitter = 0.2
+
+	// minKeepAliveInterval avoids busy refreshes of a lease close to its deadline.
+	minKeepAliveInterval = 10 * time.Millisecond
+)
+
+type LeaseEventType int
+
+const (
+	// LeaseExpiring a refresh of the lease failed, it is retried with back-off
+	// until the lease expires in Remaining.
+	LeaseExpiring LeaseEventType = iota
+
+	// LeaseLost the lease expired before a refresh succeeded, the endpoints may be removed
+	// from the registry, the refresh is still retried.
+	LeaseLost
+
+	// LeaseRecovered the lease is refreshed again after LeaseExpiring or LeaseLost.
+	LeaseRecovered
+
+	// LeaseEndpointDeleted the key of Endpoint is deleted by others, reported by WatchLease.
+	LeaseEndpointDeleted
+
+	// LeaseEndpointModified the key of Endpoint is overwritten by others, reported by WatchLease.
+	LeaseEndpointModified
+
+	// LeaseEndpointRestored Endpoint is written back by WatchLease after LeaseEndpointDeleted or LeaseEndpointModified.
+	LeaseEndpointRestored
+)
+
+func (t LeaseEventType) String() string {
+	switch t {
+	case LeaseExpiring:
+		return "lease-expiring"
+	case LeaseLost:
+		return "lease-lost"
+	case LeaseRecovered:
+		return "lease-recovered"
+	case LeaseEndpointDeleted:
+		return "lease-endpoint-deleted"
+	case LeaseEndpointModified:
+		return "lease-endpoint-modified"
+	case LeaseEndpointRestored:
+		return "lease-endpoint-restored"
+	default:
+		return "unknown"
+	}
+}
+
+// LeaseEvent of a lease kept alive by KeepAliveLease.
+type LeaseEvent struct {
+	Type  LeaseEventType
+	Lease LeaseID
+
+	// Remaining is the time left before the lease expires, it is negative once expired.
+	Remaining time.Duration
+
+	// Endpoint of the events reported by WatchLease.
+	Endpoint *Endpoint
+
+	// Err is the cause of a failed refresh.
+	Err error
+}
+
+type LeaseEventHandler func(event LeaseEvent)
+
+type keepAliveConfig struct {
+	fraction float64
+	jitter   float64
+	backoff  backoff.Config
+	handler  LeaseEventHandler
+
+	selfWatch *SelfWatchPolicy
+}
+
+type KeepAliveOption func(*keepAliveConfig)
+
+// WithKeepAliveFraction refreshes the lease once the fraction of its remaining TTL elapsed, default 1/3,
+// e.g. a 3s lease is refreshed about every second and a 1s lease about every 333ms.
+func WithKeepAliveFraction(fraction float64) KeepAliveOption {
+	return func(c *keepAliveConfig) {
+		if fraction > 0 && fraction < 1 {
+			c.fraction = fraction
+		}
+	}
+}
+
+// WithKeepAliveJitter randomizes the keepalive interval by up to ±jitter of it, in [0, 1].
+func WithKeepAliveJitter(jitter float64) KeepAliveOption {
+	return func(c *keepAliveConfig) {
+		c.jitter = jitter
+	}
+}
+
+// WithKeepAliveBackoff set the back-off of the retries of a failed refresh,
+// a retry is never delayed past half of the remaining lease window.
+func WithKeepAliveBackoff(base, max time.Duration) KeepAliveOption {
+	return func(c *keepAliveConfig) {
+		c.backoff.Base = base
+		c.backoff.Max = max
+	}
+}
+
+// WithLeaseEventHandler handles the events of the lease, e.g. fail the readiness of the process on LeaseLost.
+// The handler is called by the keepalive goroutine and must not block.
+func WithLeaseEventHandler(handler LeaseEventHandler) KeepAliveOption {
+	return func(c *keepAliveConfig) {
+		c.handler = handler
+	}
+}
+
+// WithSelfWatch watches the endpoints of the lease and applies the policy
+// when they are deleted or modified by others, see WatchLease.
+func WithSelfWatch(policy SelfWatchPolicy) KeepAliveOption {
+	return func(c *keepAliveConfig) {
+		c.selfWatch = &policy
+	}
+}
+
+// KeepAliveLease keeps the lease alive in background until ctx is done or the lease is revoked.
+func KeepAliveLease(ctx context.Context, client *Client, lease LeaseID, opts ...KeepAliveOption) error {
+	if _, err := client.TimeToLive(lease); err != nil {
+		return errors.Wrap(err, "sdr: KeepAliveLease")
+	}
+
+	cfg := &keepAliveConfig{
+		fraction: 1.0 / 3,
+		jitter:   DefaultKeepAliveJitter,
+		backoff: backoff.Config{
+			Base:       100 * time.Millisecond,
+			Max:        5 * time.Second,
+			Multiplier: 2,
+			Jitter:     DefaultKeepAliveJitter,
+		},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if cfg.selfWatch != nil {
+		if err := client.WatchLease(ctx, lease, *cfg.selfWatch, cfg.handler); err != nil {
+			return errors.Wrap(err, "sdr: KeepAliveLease")
+		}
+	}
+
+	go keepAlive(ctx, client, lease, cfg)
+	return nil
+}
+
+func keepAlive(ctx context.Context, client *Client, lease LeaseID, cfg *keepAliveConfig) {
+	var (
+		retry  = backoff.New(cfg.backoff)
+		failed bool
+		lost   bool
+	)
+
+	emit := func(event LeaseEvent) {
+		if cfg.handler != nil {
+			event.Lease = lease
+			cfg.handler(event)
+		}
+	}
+
+	for attempt := 0; ; {
+		remaining, err := client.TimeToLive(lease)
+		if err != nil {
+			// revoked
+			return
+		}
+
+		var wait time.Duration
+		if attempt == 0 {
+			wait = backoff.Jitter(time.Duration(float64(remaining)*cfg.fraction), cfg.jitter)
+		} else {
+			wait = retry.Delay(attempt - 1)
+			if remaining > 0 {
+				wait = min(wait, remaining/2)
+			}
+		}
+		timer := time.NewTimer(max(wait, minKeepAliveInterval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		err = client.KeepAlive(lease)
+		switch {
+		case err == nil:
+			if failed {
+				remaining, _ = client.TimeToLive(lease)
+				emit(LeaseEvent{Type: LeaseRecovered, Remaining: remaining})
+			}
+			failed, lost, attempt = false, false, 0
+		case errors.Is(err, ErrLeaseNotFound):
+			return
+		default:
+			remaining, _ = client.TimeToLive(lease)
+			switch {
+			case remaining <= 0:
+				if !lost {
+					emit(LeaseEvent{Type: LeaseLost, Remaining: remaining, Err: err})
+				}
+				lost = true
+			case !failed:
+				emit(LeaseEvent{Type: LeaseExpiring, Remaining: remaining, Err: err})
+			}
+			failed = true
+			attempt++
+		}
+	}
+}
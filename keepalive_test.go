@@ -0,0 +1,146 @@
+package discovery
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testLease returns a client and its lease of ttl seconds holding one endpoint.
+func testLease(t *testing.T, ttl uint32) (*Client, *fakeKV, LeaseID) {
+	t.Helper()
+	c, kv := newTestClient(t)
+	id, err := c.Grant(ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.RegisterLease(id, testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)); err != nil {
+		t.Fatal(err)
+	}
+	return c, kv, id
+}
+
+func nextLeaseEvent(t *testing.T, events <-chan LeaseEvent, timeout time.Duration) LeaseEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(timeout):
+		t.Fatal("no lease event")
+		return LeaseEvent{}
+	}
+}
+
+func TestKeepAliveLease_Transitions(t *testing.T) {
+	c, kv, id := testLease(t, 1)
+
+	var failing atomic.Bool
+	failing.Store(true)
+	kv.failWith(func(string) error {
+		if failing.Load() {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan LeaseEvent, 8)
+	if err := KeepAliveLease(ctx, c, id,
+		WithKeepAliveJitter(0),
+		WithKeepAliveBackoff(10*time.Millisecond, 50*time.Millisecond),
+		WithLeaseEventHandler(func(event LeaseEvent) {
+			events <- event
+		}),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	event := nextLeaseEvent(t, events, time.Second)
+	if event.Type != LeaseExpiring || event.Remaining <= 0 || event.Err == nil || event.Lease != id {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	// reported once, until the lease expires
+	event = nextLeaseEvent(t, events, 2*time.Second)
+	if event.Type != LeaseLost || event.Remaining > 0 {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	// the lost lease is still retried
+	failing.Store(false)
+	event = nextLeaseEvent(t, events, time.Second)
+	if event.Type != LeaseRecovered || event.Remaining <= 0 {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if _, ok := kv.value(EndpointKey(testNaming, testEndpointID(0))); !ok {
+		t.Fatal("the endpoint isn't written back")
+	}
+
+	select {
+	case event = <-events:
+		t.Fatalf("unexpected event: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestKeepAliveLease_RetryWithinLease(t *testing.T) {
+	c, kv, id := testLease(t, 1)
+
+	var writes atomic.Int64
+	kv.failWith(func(string) error {
+		writes.Add(1)
+		return errors.New("unavailable")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lost := make(chan struct{})
+	// the back-off is longer than the lease, the retries are capped by half of the remaining lease
+	if err := KeepAliveLease(ctx, c, id,
+		WithKeepAliveJitter(0),
+		WithKeepAliveBackoff(time.Minute, time.Minute),
+		WithLeaseEventHandler(func(event LeaseEvent) {
+			if event.Type == LeaseLost {
+				close(lost)
+			}
+		}),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lost:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the lease isn't lost")
+	}
+	if n := writes.Load(); n < 3 {
+		t.Fatalf("the refresh isn't retried within the lease, writes: %d", n)
+	}
+}
+
+func TestKeepAliveLease_Revoked(t *testing.T) {
+	c, _, id := testLease(t, 1)
+
+	if err := KeepAliveLease(context.Background(), c, "missing"); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var events atomic.Int64
+	if err := KeepAliveLease(context.Background(), c, id, WithLeaseEventHandler(func(LeaseEvent) {
+		events.Add(1)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Revoke(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keepalive of the revoked lease stops without reporting
+	time.Sleep(500 * time.Millisecond)
+	if n := events.Load(); n != 0 {
+		t.Fatalf("unexpected events of the revoked lease: %d", n)
+	}
+}
@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"github.com/RealFax/red-discovery/internal/grammar"
+	"strings"
+	"sync/atomic"
+)
+
+// KeyLayout is the layout of the endpoint keys in the registry.
+type KeyLayout uint32
+
+const (
+	// KeyLayoutLegacy stores endpoints at "<naming>::<id>", it's the default layout.
+	KeyLayoutLegacy KeyLayout = iota
+
+	// KeyLayoutServices stores endpoints at "services/<naming>/<id>".
+	KeyLayoutServices
+
+	// KeyLayoutDual writes and reads both layouts, it is used to migrate from KeyLayoutLegacy
+	// to KeyLayoutServices while older clients are still running.
+	// It doubles the writes and the watches, an endpoint is removed once both of its keys are deleted.
+	KeyLayoutDual
+)
+
+const (
+	// WildcardNaming matches every Naming with the prefix before it, see DiscoveryAndRegister.Discovery.
+	WildcardNaming = grammar.Wildcard
+
+	servicesKeyPrefix = "services/"
+	legacyKeySep      = "::"
+)
+
+var keyLayout atomic.Uint32
+
+func GetKeyLayout() KeyLayout {
+	return KeyLayout(keyLayout.Load())
+}
+
+// SetKeyLayout set the layout of the endpoint keys, it should be set before any Register or Discovery.
+func SetKeyLayout(layout KeyLayout) {
+	keyLayout.Store(uint32(layout))
+}
+
+// ValidateNaming checks a Naming is dot separated segments of letters, digits, '-' and '_', e.g. pkg.billing.invoice.
+func ValidateNaming(naming string) error {
+	if !grammar.ValidNaming(naming) {
+		return ErrInvalidNaming
+	}
+	return nil
+}
+
+// ValidateEndpointID checks an endpoint ID doesn't contain '/', "::", spaces or control characters,
+// e.g. a "host:port" is a valid ID.
+func ValidateEndpointID(id string) error {
+	if !grammar.ValidID(id) {
+		return ErrInvalidEndpointID
+	}
+	return nil
+}
+
+// validateDiscoveryNaming checks a Naming to discovery, which may end with WildcardNaming.
+func validateDiscoveryNaming(naming string) error {
+	if !grammar.ValidPattern(naming) {
+		return ErrInvalidNaming
+	}
+	return nil
+}
+
+func namingPrefix(naming string) (string, bool) {
+	return grammar.Prefix(naming)
+}
+
+// EndpointKey returns the key of an endpoint in the primary key layout.
+func EndpointKey(naming, id string) string {
+	if GetKeyLayout() == KeyLayoutLegacy {
+		return naming + legacyKeySep + id
+	}
+	return servicesKeyPrefix + naming + "/" + id
+}
+
+// endpointKeys returns the keys written for an endpoint, the primary key first.
+func endpointKeys(naming, id string) []string {
+	if GetKeyLayout() == KeyLayoutDual {
+		return []string{servicesKeyPrefix + naming + "/" + id, naming + legacyKeySep + id}
+	}
+	return []string{EndpointKey(naming, id)}
+}
+
+// keyPrefixes returns the key prefixes to scan and watch for the Naming of Discovery,
+// the prefix of an exact Naming is terminated, a wildcard prefix is filtered by grammar.Match.
+func keyPrefixes(naming string) []string {
+	prefix, wildcard := namingPrefix(naming)
+
+	services := servicesKeyPrefix + prefix
+	legacy := prefix
+	if !wildcard {
+		services += "/"
+		legacy += legacyKeySep
+	}
+
+	switch GetKeyLayout() {
+	case KeyLayoutLegacy:
+		return []string{legacy}
+	case KeyLayoutServices:
+		return []string{services}
+	default:
+		return []string{services, legacy}
+	}
+}
+
+// dualKey returns the key of the same endpoint in the other layout, if the layout is KeyLayoutDual.
+func dualKey(key string) (string, bool) {
+	if GetKeyLayout() != KeyLayoutDual {
+		return "", false
+	}
+	naming, id, err := ParseEndpointPath(key)
+	if err != nil {
+		return "", false
+	}
+	if strings.HasPrefix(key, servicesKeyPrefix) {
+		return naming + legacyKeySep + id, true
+	}
+	return servicesKeyPrefix + naming + "/" + id, true
+}
+
+// ParseEndpointPath parses the Naming and the endpoint ID of an endpoint key in any layout.
+func ParseEndpointPath(path string) (string, string, error) {
+	var (
+		naming, id string
+		found      bool
+	)
+	if rest, ok := strings.CutPrefix(path, servicesKeyPrefix); ok {
+		naming, id, found = strings.Cut(rest, "/")
+	} else {
+		naming, id, found = strings.Cut(path, legacyKeySep)
+	}
+	if !found || naming == "" || id == "" {
+		return "", "", ErrInvalidEndpointPathFormat
+	}
+	return naming, id, nil
+}
@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"testing"
+)
+
+// useKeyLayout sets the key layout for the test.
+func useKeyLayout(t *testing.T, layout KeyLayout) {
+	prev := GetKeyLayout()
+	SetKeyLayout(layout)
+	t.Cleanup(func() {
+		SetKeyLayout(prev)
+	})
+}
+
+func TestKeyLayout_Default(t *testing.T) {
+	if layout := KeyLayout(0); layout != KeyLayoutLegacy {
+		t.Fatalf("unexpected default key layout: %d", layout)
+	}
+}
+
+func TestDiscovery_DualLayoutDelete(t *testing.T) {
+	useKeyLayout(t, KeyLayoutDual)
+	r, kv := newTestRegister(t)
+
+	if err := r.Discovery(testNaming); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return kv.watching(2) }, "the layouts aren't watched")
+
+	if err := r.Register(testNaming, &Endpoint{ID: testEndpointID(0), PeerAddress: "127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := r.loadService(testNaming)
+	keys := endpointKeys(testNaming, testEndpointID(0))
+
+	// the legacy key expires, the endpoint is kept by its services key
+	kv.expire(keys[1])
+
+	// the events of a layout are handled in order, the next endpoint is added after the expiry was handled
+	next, _ := (&Endpoint{ID: testEndpointID(1), PeerAddress: "127.0.0.1:1"}).Marshal()
+	if err := kv.Set(r.ctx, []byte(testNaming+legacyKeySep+testEndpointID(1)), next, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		_, ok := srv.Endpoint(testEndpointID(1))
+		return ok
+	}, "the next endpoint isn't discovered")
+	if _, ok := srv.Endpoint(testEndpointID(0)); !ok {
+		t.Fatal("the endpoint is dropped while its services key exists")
+	}
+
+	// both of the keys are expired
+	kv.expire(keys[0])
+	eventually(t, func() bool {
+		_, ok := srv.Endpoint(testEndpointID(0))
+		return !ok
+	}, "the endpoint isn't dropped once both of its keys expired")
+}
+
+func TestDiscovery_SingleLayoutDelete(t *testing.T) {
+	useKeyLayout(t, KeyLayoutLegacy)
+	r, kv := newTestRegister(t)
+
+	if err := r.Discovery(testNaming); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return kv.watching(1) }, "the layout isn't watched")
+
+	if err := r.Register(testNaming, &Endpoint{ID: "10.0.0.1:8080", PeerAddress: "127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := r.loadService(testNaming)
+
+	kv.expire(EndpointKey(testNaming, "10.0.0.1:8080"))
+	eventually(t, func() bool {
+		_, ok := srv.Endpoint("10.0.0.1:8080")
+		return !ok
+	}, "the expired endpoint isn't dropped")
+}
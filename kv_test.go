@@ -0,0 +1,325 @@
+package discovery
+
+import (
+	"context"
+	"github.com/RealFax/RedQueen/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeWatch struct {
+	ctx    context.Context
+	prefix string
+	ch     chan *client.WatchValue
+}
+
+// fakeKV is an in-memory registry, the namespaces are ignored and the TTL isn't enforced, see expire.
+type fakeKV struct {
+	mu       sync.Mutex
+	values   map[string]*client.Value
+	watchers map[*client.Watcher]*fakeWatch
+
+	// fail returns the error of a write to key, if it isn't nil, see failWith.
+	fail func(key string) error
+
+	// failScan returns the error of the scan of a page at offset, if it isn't nil, see failScanWith.
+	failScan func(offset uint64) error
+
+	// scans counts the calls of PrefixScan.
+	scans atomic.Int64
+}
+
+// failScanWith set the hook of the scans.
+func (kv *fakeKV) failScanWith(fail func(offset uint64) error) {
+	kv.mu.Lock()
+	kv.failScan = fail
+	kv.mu.Unlock()
+}
+
+// failWith set the hook of the writes, it is called before the write and may block.
+func (kv *fakeKV) failWith(fail func(key string) error) {
+	kv.mu.Lock()
+	kv.fail = fail
+	kv.mu.Unlock()
+}
+
+func (kv *fakeKV) check(key string) error {
+	kv.mu.Lock()
+	fail := kv.fail
+	kv.mu.Unlock()
+	if fail == nil {
+		return nil
+	}
+	return fail(key)
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{
+		values:   make(map[string]*client.Value),
+		watchers: make(map[*client.Watcher]*fakeWatch),
+	}
+}
+
+func (kv *fakeKV) notify(key string, value []byte, ttl uint32) {
+	kv.mu.Lock()
+	watches := make([]*fakeWatch, 0, len(kv.watchers))
+	for _, w := range kv.watchers {
+		if strings.HasPrefix(key, w.prefix) {
+			watches = append(watches, w)
+		}
+	}
+	kv.mu.Unlock()
+
+	for _, w := range watches {
+		func() {
+			// the watcher may be closed by its owner at any time
+			defer func() {
+				_ = recover()
+			}()
+			select {
+			case w.ch <- &client.WatchValue{Timestamp: time.Now().UnixMilli(), TTL: ttl, Key: []byte(key), Value: value}:
+			case <-w.ctx.Done():
+			}
+		}()
+	}
+}
+
+func (kv *fakeKV) write(key string, value []byte, ttl uint32, try bool) error {
+	if err := kv.check(key); err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	if _, ok := kv.values[key]; ok && try {
+		kv.mu.Unlock()
+		return status.Error(codes.Internal, "key exists")
+	}
+	kv.values[key] = &client.Value{Key: []byte(key), Data: append([]byte(nil), value...), TTL: ttl}
+	kv.mu.Unlock()
+
+	kv.notify(key, value, ttl)
+	return nil
+}
+
+func (kv *fakeKV) Set(_ context.Context, key, value []byte, ttl uint32, _ *string) error {
+	return kv.write(string(key), value, ttl, false)
+}
+
+func (kv *fakeKV) TrySet(_ context.Context, key, value []byte, ttl uint32, _ *string) error {
+	return kv.write(string(key), value, ttl, true)
+}
+
+func (kv *fakeKV) Get(_ context.Context, key []byte, _ *string) (*client.Value, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, ok := kv.values[string(key)]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+	return &client.Value{Key: value.Key, Data: append([]byte(nil), value.Data...), TTL: value.TTL}, nil
+}
+
+func (kv *fakeKV) PrefixScan(_ context.Context, prefix []byte, offset, limit uint64, _, _ *string) ([]*client.Value, error) {
+	kv.scans.Add(1)
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.failScan != nil {
+		if err := kv.failScan(offset); err != nil {
+			return nil, err
+		}
+	}
+	keys := make([]string, 0, len(kv.values))
+	for key := range kv.values {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	values := make([]*client.Value, 0, limit)
+	for i := offset; i < uint64(len(keys)) && uint64(len(values)) < limit; i++ {
+		value := kv.values[keys[i]]
+		values = append(values, &client.Value{Key: value.Key, Data: append([]byte(nil), value.Data...), TTL: value.TTL})
+	}
+	return values, nil
+}
+
+func (kv *fakeKV) Delete(_ context.Context, key []byte, _ *string) error {
+	if err := kv.check(string(key)); err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	_, ok := kv.values[string(key)]
+	delete(kv.values, string(key))
+	kv.mu.Unlock()
+
+	if ok {
+		kv.notify(string(key), nil, 0)
+	}
+	return nil
+}
+
+// expire deletes key as the registry does once its TTL passed.
+func (kv *fakeKV) expire(key string) {
+	kv.mu.Lock()
+	delete(kv.values, key)
+	kv.mu.Unlock()
+	kv.notify(key, nil, 0)
+}
+
+func (kv *fakeKV) value(key string) ([]byte, bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, ok := kv.values[key]
+	if !ok {
+		return nil, false
+	}
+	return value.Data, true
+}
+
+func (kv *fakeKV) Watch(ctx context.Context, _ *client.Watcher) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (kv *fakeKV) WatchPrefix(ctx context.Context, watcher *client.Watcher) error {
+	ch, err := watcher.Notify()
+	if err != nil {
+		return err
+	}
+	// the prefix of a watcher isn't exported
+	prefix := reflect.ValueOf(watcher).Elem().FieldByName("key").Bytes()
+
+	kv.mu.Lock()
+	kv.watchers[watcher] = &fakeWatch{ctx: ctx, prefix: string(prefix), ch: ch}
+	kv.mu.Unlock()
+
+	<-ctx.Done()
+	kv.mu.Lock()
+	delete(kv.watchers, watcher)
+	kv.mu.Unlock()
+	return ctx.Err()
+}
+
+// watching reports whether n watchers are registered.
+func (kv *fakeKV) watching(n int) bool {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return len(kv.watchers) == n
+}
+
+// newTestClient returns a Client of an in-memory registry.
+func newTestClient(t *testing.T) (*Client, *fakeKV) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	kv := newFakeKV()
+	c := NewWithClient(ctx, nil, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	c.DiscoveryAndRegister.(*discoveryAndRegister).kvClient = kv
+	return c, kv
+}
+
+// newTestRegister returns the DiscoveryAndRegister of newTestClient.
+func newTestRegister(t *testing.T) (*discoveryAndRegister, *fakeKV) {
+	t.Helper()
+	c, kv := newTestClient(t)
+	return c.DiscoveryAndRegister.(*discoveryAndRegister), kv
+}
+
+// eventually fails the test unless cond is true within 5s.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRegister_Undiscovered(t *testing.T) {
+	r, kv := newTestRegister(t)
+
+	if err := r.Register(testNaming, &Endpoint{ID: testEndpointID(0), PeerAddress: "127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.value(EndpointKey(testNaming, testEndpointID(0))); !ok {
+		t.Fatal("the endpoint isn't written")
+	}
+	if _, ok := r.loadService(testNaming).Endpoint(testEndpointID(0)); !ok {
+		t.Fatal("the endpoint isn't added to its service")
+	}
+}
+
+func TestClient_WaitForServiceIncomplete(t *testing.T) {
+	prev := ScanPageSize()
+	SetScanPageSize(1)
+	t.Cleanup(func() {
+		SetScanPageSize(prev)
+	})
+
+	c, kv := newTestClient(t)
+	ts := newTestServer(t, reply)
+	for i := 0; i < 2; i++ {
+		endpoint := NewEndpoint(testEndpointID(i), ts.addr, 60, nil)
+		endpoint.Naming = testNaming
+		value, _ := endpoint.Marshal()
+		if err := kv.Set(context.Background(), []byte(EndpointKey(testNaming, testEndpointID(i))), value, 60, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the scan fails after the first page
+	kv.failScanWith(func(offset uint64) error {
+		if offset > 0 {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv, err := c.WaitForService(ctx, testNaming, 1)
+	if err != nil {
+		t.Fatalf("unexpected error of the incomplete discovery: %v", err)
+	}
+	if srv.ReadyCount() != 1 {
+		t.Fatalf("unexpected ready endpoints: %d", srv.ReadyCount())
+	}
+}
+
+func TestDiscovery_LoadServiceOnce(t *testing.T) {
+	r, _ := newTestRegister(t)
+
+	// the build is slowed down to overlap the concurrent loads
+	var built atomic.Int64
+	r.UseServiceOptions(testNaming, func(*service) {
+		built.Add(1)
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.loadService(testNaming)
+		}()
+	}
+	wg.Wait()
+	if n := built.Load(); n != 1 {
+		t.Fatalf("unexpected services built: %d", n)
+	}
+}
@@ -0,0 +1,243 @@
+package discovery
+
+import (
+	"github.com/RealFax/red-discovery/internal/hack"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// keepAliveConcurrency is the number of keys refreshed at once by KeepAlive.
+	keepAliveConcurrency = 16
+)
+
+// LeaseID identifies a lease returned by Grant.
+type LeaseID string
+
+// lease binds the endpoints registered by RegisterLease to a TTL,
+// they are refreshed by KeepAlive and unregistered by Revoke together.
+type lease struct {
+	ttl       uint32
+	deadline  atomic.Int64 // unix milli, the endpoints expire unless refreshed before it
+	mu        sync.Mutex
+	endpoints map[string]*Endpoint // map<endpoint key, *Endpoint>
+	watch     *selfWatch
+
+	// alive is held by KeepAlive while writing, Revoke waits for the writes in flight before unregistering.
+	alive   sync.RWMutex
+	revoked bool
+}
+
+func (l *lease) refresh(at time.Time) {
+	l.deadline.Store(at.Add(time.Duration(l.ttl) * time.Second).UnixMilli())
+}
+
+func (l *lease) attach(endpoints ...*Endpoint) {
+	l.mu.Lock()
+	for _, endpoint := range endpoints {
+		l.endpoints[EndpointKey(endpoint.Naming, endpoint.ID)] = endpoint
+	}
+	l.mu.Unlock()
+}
+
+// update replaces the attached endpoints of the same keys, the endpoints which aren't attached are ignored.
+func (l *lease) update(endpoints ...*Endpoint) {
+	l.mu.Lock()
+	for _, endpoint := range endpoints {
+		key := EndpointKey(endpoint.Naming, endpoint.ID)
+		if _, ok := l.endpoints[key]; ok {
+			endpoint.SetTTL(l.ttl)
+			l.endpoints[key] = endpoint
+		}
+	}
+	l.mu.Unlock()
+}
+
+func (l *lease) detach(naming, id string) {
+	l.mu.Lock()
+	delete(l.endpoints, EndpointKey(naming, id))
+	l.mu.Unlock()
+}
+
+func (l *lease) snapshot() []*Endpoint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	endpoints := make([]*Endpoint, 0, len(l.endpoints))
+	for _, endpoint := range l.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+func (r *discoveryAndRegister) Grant(ttl uint32) (LeaseID, error) {
+	if ttl == 0 {
+		return "", ErrInvalidLeaseTTL
+	}
+	var (
+		id = LeaseID(uuid.NewString())
+		l  = &lease{ttl: ttl, endpoints: make(map[string]*Endpoint)}
+	)
+	l.refresh(time.Now())
+	r.leases.Store(id, l)
+	return id, nil
+}
+
+func (r *discoveryAndRegister) TimeToLive(id LeaseID) (time.Duration, error) {
+	l, ok := r.leases.Load(id)
+	if !ok {
+		return 0, ErrLeaseNotFound
+	}
+	return time.Until(time.UnixMilli(l.deadline.Load())), nil
+}
+
+func (r *discoveryAndRegister) RegisterLease(id LeaseID, naming string, endpoints ...*Endpoint) error {
+	l, ok := r.leases.Load(id)
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	for _, endpoint := range endpoints {
+		endpoint.SetTTL(l.ttl)
+	}
+	registered, err := r.register(naming, endpoints...)
+	l.attach(registered...)
+
+	l.mu.Lock()
+	w := l.watch
+	l.mu.Unlock()
+	if w != nil && len(registered) != 0 {
+		r.startSelfWatch(l, w, naming)
+	}
+	return err
+}
+
+func (r *discoveryAndRegister) KeepAlive(id LeaseID) error {
+	l, ok := r.leases.Load(id)
+	if !ok {
+		return ErrLeaseNotFound
+	}
+
+	l.alive.RLock()
+	defer l.alive.RUnlock()
+	if l.revoked {
+		return ErrLeaseNotFound
+	}
+
+	var (
+		start     = time.Now()
+		endpoints = l.snapshot()
+		values    = make([][]byte, len(endpoints))
+		failed    = make([]bool, len(endpoints))
+		sem       = make(chan struct{}, keepAliveConcurrency)
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		firstErr  error
+		keys      int
+		errs      int
+	)
+	// the registry rejects a write without value, the endpoints are written again with the TTL of the lease
+	for i, endpoint := range endpoints {
+		value, err := endpoint.Marshal()
+		if err != nil {
+			return errors.Wrapf(err, "sdr: KeepAlive %s", endpoint.ID)
+		}
+		values[i] = value
+	}
+
+	write := func(i, n int, fn func() error) {
+		keys += n
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(); err != nil {
+				mu.Lock()
+				failed[i] = true
+				if firstErr == nil {
+					firstErr = err
+				}
+				errs += n
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i, endpoint := range endpoints {
+		var (
+			i, endpoint = i, endpoint
+			value       = values[i]
+			paths       = endpointKeys(endpoint.Naming, endpoint.ID)
+		)
+		// a claimed endpoint may be taken by another process once its key expired, the claim is checked
+		if endpoint.Owner != "" {
+			write(i, len(paths), func() error {
+				err := r.claimEndpoint(endpoint.Naming, endpoint, value, l.ttl)
+				var conflict *EndpointConflictError
+				if errors.As(err, &conflict) {
+					// no longer refreshed by the lease
+					l.detach(endpoint.Naming, endpoint.ID)
+				}
+				return err
+			})
+			continue
+		}
+		for _, key := range paths {
+			key := key
+			write(i, 1, func() error {
+				return r.kvClient.Set(r.ctx, hack.String2Bytes(key), value, l.ttl, namespace.Load())
+			})
+		}
+	}
+	wg.Wait()
+
+	now := time.Now().UnixMilli()
+	for i, endpoint := range endpoints {
+		if failed[i] {
+			continue
+		}
+		if srv, ok := r.services.Load(endpoint.Naming); ok {
+			endpoint.touch(now)
+			srv.AddEndpoints(endpoint)
+		}
+	}
+
+	if firstErr != nil {
+		return errors.Wrapf(firstErr, "sdr: KeepAlive %d of %d keys failed", errs, keys)
+	}
+	l.refresh(start)
+	return nil
+}
+
+func (r *discoveryAndRegister) Revoke(id LeaseID) (err error) {
+	l, ok := r.leases.LoadAndDelete(id)
+	if !ok {
+		return ErrLeaseNotFound
+	}
+
+	// the revoked endpoints must not be written back, neither by the self watch nor by a KeepAlive in flight
+	l.mu.Lock()
+	if l.watch != nil {
+		l.watch.cancel()
+	}
+	l.mu.Unlock()
+
+	l.alive.Lock()
+	l.revoked = true
+	l.alive.Unlock()
+
+	ids := make(map[string][]string) // map<naming, []id>
+	for _, endpoint := range l.snapshot() {
+		ids[endpoint.Naming] = append(ids[endpoint.Naming], endpoint.ID)
+	}
+	for naming, endpointIDs := range ids {
+		if uerr := r.Unregister(naming, endpointIDs...); uerr != nil && err == nil {
+			err = errors.Wrap(uerr, naming)
+		}
+	}
+	return
+}
@@ -0,0 +1,217 @@
+package discovery
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLease_Grant(t *testing.T) {
+	r, _ := newTestRegister(t)
+
+	if _, err := r.Grant(0); !errors.Is(err, ErrInvalidLeaseTTL) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.TimeToLive("missing"); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := r.Grant(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := r.TimeToLive(id); ttl <= 2*time.Second || ttl > 3*time.Second {
+		t.Fatalf("unexpected time to live: %s", ttl)
+	}
+}
+
+func TestLease_RegisterLease(t *testing.T) {
+	r, kv := newTestRegister(t)
+
+	endpoint := NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)
+	if err := r.RegisterLease("missing", testNaming, endpoint); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, _ := r.Grant(5)
+	if err := r.RegisterLease(id, testNaming, endpoint, NewEndpoint(testEndpointID(1), "127.0.0.1:2", 60, nil)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		value, err := kv.Get(context.Background(), []byte(EndpointKey(testNaming, testEndpointID(i))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value.TTL != 5 {
+			t.Fatalf("the endpoint isn't written with the TTL of the lease: %d", value.TTL)
+		}
+	}
+	if l, _ := r.leases.Load(id); len(l.snapshot()) != 2 {
+		t.Fatalf("unexpected attached endpoints: %d", len(l.snapshot()))
+	}
+}
+
+func TestLease_KeepAlive(t *testing.T) {
+	r, kv := newTestRegister(t)
+
+	if err := r.KeepAlive("missing"); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, _ := r.Grant(1)
+	if err := r.RegisterLease(id, testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)); err != nil {
+		t.Fatal(err)
+	}
+	key := EndpointKey(testNaming, testEndpointID(0))
+
+	// the expired key is written back, the lease is refreshed
+	kv.expire(key)
+	time.Sleep(100 * time.Millisecond)
+	if err := r.KeepAlive(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.value(key); !ok {
+		t.Fatal("the expired key isn't written back")
+	}
+	if ttl, _ := r.TimeToLive(id); ttl <= 900*time.Millisecond {
+		t.Fatalf("the lease isn't refreshed: %s", ttl)
+	}
+
+	// a failed refresh doesn't extend the lease
+	kv.failWith(func(string) error {
+		return errors.New("unavailable")
+	})
+	time.Sleep(100 * time.Millisecond)
+	if err := r.KeepAlive(id); err == nil {
+		t.Fatal("expected the error of the write")
+	}
+	if ttl, _ := r.TimeToLive(id); ttl > 900*time.Millisecond {
+		t.Fatalf("the lease is refreshed by a failed KeepAlive: %s", ttl)
+	}
+}
+
+func TestLease_Revoke(t *testing.T) {
+	r, kv := newTestRegister(t)
+
+	id, _ := r.Grant(5)
+	if err := r.RegisterLease(id, testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Revoke(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.value(EndpointKey(testNaming, testEndpointID(0))); ok {
+		t.Fatal("the key of the revoked lease isn't deleted")
+	}
+	if _, ok := r.loadService(testNaming).Endpoint(testEndpointID(0)); ok {
+		t.Fatal("the endpoint of the revoked lease isn't removed")
+	}
+	if err := r.KeepAlive(id); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Revoke(id); !errors.Is(err, ErrLeaseNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLease_RevokeKeepAliveInFlight(t *testing.T) {
+	r, kv := newTestRegister(t)
+
+	id, _ := r.Grant(5)
+	if err := r.RegisterLease(id, testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the write of KeepAlive is held until the Revoke is started
+	var (
+		writes  atomic.Int64
+		entered = make(chan struct{})
+		release = make(chan struct{})
+	)
+	kv.failWith(func(string) error {
+		if writes.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+
+	keepAlive := make(chan error, 1)
+	go func() {
+		keepAlive <- r.KeepAlive(id)
+	}()
+	<-entered
+
+	revoke := make(chan error, 1)
+	go func() {
+		revoke <- r.Revoke(id)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := <-keepAlive; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-revoke; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.value(EndpointKey(testNaming, testEndpointID(0))); ok {
+		t.Fatal("the key is written back by the KeepAlive in flight")
+	}
+}
+
+func TestAutoKeepAlive_Revoke(t *testing.T) {
+	c, kv := newTestClient(t)
+	r := c.DiscoveryAndRegister.(*discoveryAndRegister)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := AutoKeepAlive(ctx, testNaming, c, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil)); err != nil {
+		t.Fatal(err)
+	}
+	key := EndpointKey(testNaming, testEndpointID(0))
+	if _, ok := kv.value(key); !ok {
+		t.Fatal("the endpoint isn't registered")
+	}
+
+	cancel()
+	eventually(t, func() bool {
+		_, ok := kv.value(key)
+		return !ok
+	}, "the endpoint isn't unregistered once ctx is done")
+	eventually(t, func() bool {
+		leases := 0
+		r.leases.Range(func(LeaseID, *lease) bool {
+			leases++
+			return true
+		})
+		return leases == 0
+	}, "the lease isn't revoked once ctx is done")
+}
+
+func TestLease_RegisterAgain(t *testing.T) {
+	r, kv := newTestRegister(t)
+
+	id, _ := r.Grant(5)
+	if err := r.RegisterLease(id, testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 5, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the metadata is updated by a plain Register
+	if err := r.Register(testNaming, NewEndpoint(testEndpointID(0), "127.0.0.1:1", 5, []byte(`{"weight":2}`))); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.KeepAlive(id); err != nil {
+		t.Fatal(err)
+	}
+	value, _ := kv.value(EndpointKey(testNaming, testEndpointID(0)))
+	endpoint, err := ParseEndpoint(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(endpoint.Metadata) != `{"weight":2}` {
+		t.Fatalf("the registration is overwritten by the lease: %s", endpoint.Metadata)
+	}
+}
@@ -0,0 +1,155 @@
+package discovery
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/limiter"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// LimitsMetadataKey is the key of the limits published in Endpoint.Metadata.
+	LimitsMetadataKey = "sdr-limits"
+)
+
+// Limits of the client side traffic, the zero value of a field means unlimited.
+type Limits struct {
+	// Rate is the number of requests per second.
+	Rate float64 `json:"rate,omitempty"`
+
+	// Burst is the maximum number of requests allowed at once, default is 1.
+	Burst int `json:"burst,omitempty"`
+
+	// MaxConcurrent is the maximum number of in-flight requests.
+	MaxConcurrent int `json:"max-concurrent,omitempty"`
+}
+
+func (l Limits) Unlimited() bool {
+	return l.Rate <= 0 && l.MaxConcurrent <= 0
+}
+
+// trafficLimiter enforces Limits, a nil *trafficLimiter is unlimited.
+type trafficLimiter struct {
+	bucket *limiter.TokenBucket
+	sem    *limiter.Semaphore
+}
+
+// allow takes a rate token, it is used when the caller doesn't report the end of the request.
+func (l *trafficLimiter) allow() bool {
+	return l == nil || l.bucket == nil || l.bucket.Allow()
+}
+
+// tryAcquire takes a rate token and a concurrency slot without blocking.
+func (l *trafficLimiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	if l.sem != nil && !l.sem.TryAcquire() {
+		return false
+	}
+	if !l.allow() {
+		l.release()
+		return false
+	}
+	return true
+}
+
+// acquire blocks until a rate token and a concurrency slot are taken.
+func (l *trafficLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if l.sem != nil {
+		if err := l.sem.Acquire(ctx); err != nil {
+			return err
+		}
+	}
+	if l.bucket != nil {
+		if err := l.bucket.Wait(ctx); err != nil {
+			l.release()
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *trafficLimiter) release() {
+	if l != nil && l.sem != nil {
+		l.sem.Release()
+	}
+}
+
+func newTrafficLimiter(l Limits) *trafficLimiter {
+	if l.Unlimited() {
+		return nil
+	}
+	tl := &trafficLimiter{}
+	if l.Rate > 0 {
+		tl.bucket = limiter.NewTokenBucket(l.Rate, l.Burst)
+	}
+	if l.MaxConcurrent > 0 {
+		tl.sem = limiter.NewSemaphore(l.MaxConcurrent)
+	}
+	return tl
+}
+
+// WithLimits set the limits of the whole service.
+//
+// NextAliveConn only consumes the rate, concurrency is limited by Invoke and NewStream.
+func WithLimits(l Limits) ServiceOption {
+	return func(s *service) {
+		s.limiter.Store(newTrafficLimiter(l))
+	}
+}
+
+// WithEndpointLimits set the limits of every endpoint in the service,
+// the limits published by the endpoint take precedence when WithPublishedLimits is used.
+func WithEndpointLimits(l Limits) ServiceOption {
+	return func(s *service) {
+		s.endpointLimits.Store(&l)
+		s.resetEndpointLimiters()
+	}
+}
+
+// WithPublishedLimits enable the limits published by the endpoints in their metadata, see Endpoint.PutLimits.
+func WithPublishedLimits() ServiceOption {
+	return func(s *service) {
+		s.publishedLimits.Store(true)
+		s.resetEndpointLimiters()
+	}
+}
+
+// PutLimits publish the capacity of the endpoint in its metadata,
+// it is merged into the existing metadata, which must be a JSON object.
+func (e *Endpoint) PutLimits(l Limits) error {
+	m := make(map[string]jsoniter.RawMessage)
+	if len(e.Metadata) != 0 {
+		if err := jsoniter.ConfigFastest.Unmarshal(e.Metadata, &m); err != nil {
+			return err
+		}
+	}
+
+	b, err := jsoniter.ConfigFastest.Marshal(l)
+	if err != nil {
+		return err
+	}
+	m[LimitsMetadataKey] = b
+
+	e.Metadata, err = jsoniter.ConfigFastest.Marshal(m)
+	return err
+}
+
+// Limits returns the limits published by the endpoint.
+func (e *Endpoint) Limits() (Limits, bool) {
+	var (
+		l Limits
+		m map[string]jsoniter.RawMessage
+	)
+	if len(e.Metadata) == 0 || jsoniter.ConfigFastest.Unmarshal(e.Metadata, &m) != nil {
+		return l, false
+	}
+	raw, ok := m[LimitsMetadataKey]
+	if !ok || jsoniter.ConfigFastest.Unmarshal(raw, &l) != nil {
+		return l, false
+	}
+	return l, true
+}
@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"io"
+	"testing"
+	"time"
+)
+
+// drain answers a client stream once the client closes it.
+func drain(stream grpc.ServerStream) error {
+	for {
+		if err := stream.RecvMsg(&emptypb.Empty{}); errors.Is(err, io.EOF) {
+			return stream.SendMsg(&emptypb.Empty{})
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func TestService_EndpointLimitsClientStreams(t *testing.T) {
+	var (
+		srv  = newTestService(t, []*testServer{newTestServer(t, drain)}, WithEndpointLimits(Limits{MaxConcurrent: 2}))
+		desc = &grpc.StreamDesc{ClientStreams: true}
+		ctx  = context.Background()
+	)
+
+	streams := make([]grpc.ClientStream, 2)
+	for i := range streams {
+		stream, err := srv.NewStream(ctx, desc, testMethod, WithInvokeTimeout(5*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams[i] = stream
+	}
+	if _, err := srv.NewStream(ctx, desc, testMethod); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("the open streams didn't hold the limit: %v", err)
+	}
+
+	// the single response finishes the client stream
+	if err := streams[0].CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := streams[0].RecvMsg(&emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.NewStream(ctx, desc, testMethod, WithInvokeTimeout(5*time.Second)); err != nil {
+		t.Fatalf("the finished stream didn't release the limit: %v", err)
+	}
+}
+
+func TestService_ReleaseReservedLimiter(t *testing.T) {
+	var (
+		srv  = newTestService(t, []*testServer{newTestServer(t, drain)}, WithEndpointLimits(Limits{MaxConcurrent: 1}))
+		desc = &grpc.StreamDesc{ClientStreams: true}
+		ctx  = context.Background()
+	)
+
+	prev, err := srv.NewStream(ctx, desc, testMethod, WithInvokeTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the limiter is replaced while the stream holds the slot of the previous one
+	srv.Configure(WithEndpointLimits(Limits{MaxConcurrent: 1}))
+	if _, err = srv.NewStream(ctx, desc, testMethod, WithInvokeTimeout(5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the finished stream releases the previous limiter, the slot of the next one is still held
+	if err = prev.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err = prev.RecvMsg(&emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = srv.NewStream(ctx, desc, testMethod); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("the slot of the replaced limiter is released: %v", err)
+	}
+}
+
+func TestService_HalfOpenCircuitKeepsRateTokens(t *testing.T) {
+	srv := newTestService(
+		t,
+		[]*testServer{newTestServer(t, reply)},
+		WithEndpointLimits(Limits{Rate: 1, Burst: 1}),
+		WithCircuitBreaker(testBreakerConfig),
+	)
+	halfOpen(t, srv, testEndpointID(0))
+
+	// the probe is taken by another call
+	b, _ := srv.breakers.Load(testEndpointID(0))
+	b.Allow()
+	for i := 0; i < 3; i++ {
+		if err := invokeTest(srv); !errors.Is(err, ErrServiceUnreachable) {
+			t.Fatalf("unexpected error without a probe: %v", err)
+		}
+	}
+
+	// the token of the burst is still there once the probe is given back
+	b.Cancel()
+	if err := invokeTest(srv); err != nil {
+		t.Fatalf("the calls without a probe spent the rate token: %v", err)
+	}
+}
+
+func TestService_ConfigureLimitsConcurrently(t *testing.T) {
+	srv := newTestService(t, []*testServer{newTestServer(t, reply)})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			srv.Configure(WithEndpointLimits(Limits{MaxConcurrent: i + 1}), WithPublishedLimits())
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		srv.AddEndpoints(NewEndpoint(testEndpointID(i+1), "127.0.0.1:1", 60, nil))
+		_ = invokeTest(srv)
+	}
+	<-done
+}
@@ -0,0 +1,84 @@
+package discovery
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/dispatch"
+	"google.golang.org/grpc"
+	"time"
+)
+
+// ListenerPolicy decides what happens to the state changes of a Naming when the listener queue is full.
+type ListenerPolicy = dispatch.Policy
+
+const (
+	// ListenerBlock blocks the discovery of the Naming until the listener catches up.
+	ListenerBlock = dispatch.Block
+
+	// ListenerDropOldest discards the oldest pending state change.
+	ListenerDropOldest = dispatch.DropOldest
+
+	// ListenerDropNewest discards the new state change.
+	ListenerDropNewest = dispatch.DropNewest
+
+	// ListenerCoalesce only keeps the latest pending state change, it's the default policy.
+	ListenerCoalesce = dispatch.Coalesce
+)
+
+// ListenerStats of the deliveries to a listener, Slow counts the callbacks exceeding the slow threshold,
+// Unacked counts the callbacks which didn't call ack.Done within the ack timeout.
+type ListenerStats = dispatch.Stats
+
+type ListenerOption = dispatch.Option
+
+// WithListenerBuffer set the number of pending state changes of the listener, default is 16.
+func WithListenerBuffer(n int) ListenerOption {
+	return dispatch.WithBuffer(n)
+}
+
+// WithListenerPolicy set the policy of a full listener queue, default is ListenerCoalesce.
+func WithListenerPolicy(p ListenerPolicy) ListenerOption {
+	return dispatch.WithPolicy(p)
+}
+
+// WithSlowListenerThreshold set the duration after which a callback is counted as slow, default is 1s.
+func WithSlowListenerThreshold(d time.Duration) ListenerOption {
+	return dispatch.WithSlowThreshold(d)
+}
+
+// WithListenerAckTimeout set the time the next state change waits for the callback to call ack.Done, default is 10s.
+func WithListenerAckTimeout(d time.Duration) ListenerOption {
+	return dispatch.WithAckTimeout(d)
+}
+
+// ListenerAck acknowledges a state change delivered to a listener callback,
+// the next state change is delivered once Done is called, or the ack timeout passed.
+type ListenerAck interface {
+	Done()
+}
+
+// ackFunc is the ListenerAck of a listener queue, Done acknowledges the delivery directly.
+type ackFunc func()
+
+func (f ackFunc) Done() {
+	f()
+}
+
+// GlobalListenCallbackFunc is the callback of UseGlobalListener, naming is the Naming whose state changed.
+// The service is ready once a ready endpoint has a dialed connection, which is given as conn.
+type GlobalListenCallbackFunc func(naming string, ready bool, conn *grpc.ClientConn, ack ListenerAck)
+
+type listenerEvent struct {
+	naming string
+	ready  bool
+	conn   *grpc.ClientConn
+}
+
+type listenerQueue = dispatch.Queue[listenerEvent]
+
+// newListenerQueue delivers the state changes to callback in order on its own goroutine,
+// the next state change is delivered after the callback calls ack.Done, or the ack timeout passed.
+func newListenerQueue(ctx context.Context, callback GlobalListenCallbackFunc, opts ...ListenerOption) *listenerQueue {
+	return dispatch.NewAck(ctx, func(e listenerEvent, ack func()) {
+		callback(e.naming, e.ready, e.conn, ackFunc(ack))
+	}, opts...)
+}
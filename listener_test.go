@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"testing"
+	"time"
+)
+
+func TestListenerQueue_MissingDone(t *testing.T) {
+	got := make(chan bool, 2)
+	q := newListenerQueue(context.Background(), func(_ string, ready bool, _ *grpc.ClientConn, _ ListenerAck) {
+		// ack.Done is never called
+		got <- ready
+	}, WithListenerAckTimeout(10*time.Millisecond), WithListenerPolicy(ListenerBlock))
+	defer q.Close()
+
+	q.Push(listenerEvent{naming: testNaming, ready: true})
+	q.Push(listenerEvent{naming: testNaming, ready: false})
+	for _, want := range []bool{true, false} {
+		select {
+		case ready := <-got:
+			if ready != want {
+				t.Fatalf("unexpected state change: %v", ready)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the listener queue is blocked by the missing ack.Done")
+		}
+	}
+}
+
+func TestListenerQueue_AckDone(t *testing.T) {
+	got := make(chan bool, 2)
+	q := newListenerQueue(context.Background(), func(_ string, ready bool, _ *grpc.ClientConn, ack ListenerAck) {
+		got <- ready
+		// acknowledged twice, the delivery is acknowledged once
+		ack.Done()
+		ack.Done()
+	}, WithListenerAckTimeout(time.Hour), WithListenerPolicy(ListenerBlock))
+	defer q.Close()
+
+	q.Push(listenerEvent{naming: testNaming, ready: true})
+	q.Push(listenerEvent{naming: testNaming, ready: false})
+	for _, want := range []bool{true, false} {
+		select {
+		case ready := <-got:
+			if ready != want {
+				t.Fatalf("unexpected state change: %v", ready)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the next state change isn't delivered once acknowledged")
+		}
+	}
+	if stats := q.Stats(); stats.Unacked != 0 {
+		t.Fatalf("unexpected unacked deliveries: %d", stats.Unacked)
+	}
+}
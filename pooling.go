@@ -0,0 +1,126 @@
+package discovery
+
+import (
+	"context"
+	"github.com/RealFax/red-discovery/internal/pool"
+	"time"
+)
+
+const (
+	DefaultPoolSize        = 16
+	DefaultDialConcurrency = 8
+	DefaultDialTimeout     = 10 * time.Second
+)
+
+// minIdleCheckInterval bounds the period of checking the idle connections.
+const minIdleCheckInterval = 10 * time.Millisecond
+
+// WithPoolSize set the maximum number of connections to each endpoint, default is DefaultPoolSize.
+func WithPoolSize(size int) ServiceOption {
+	return func(s *service) {
+		if size > 0 {
+			s.poolSize.Store(int64(size))
+		}
+	}
+}
+
+// WithDialConcurrency set the maximum number of endpoints dialed at once, default is DefaultDialConcurrency.
+func WithDialConcurrency(n int) ServiceOption {
+	return func(s *service) {
+		if n > 0 {
+			s.dialConcurrency.Store(int64(n))
+		}
+	}
+}
+
+// WithDialTimeout set the timeout of dialing a connection, default is DefaultDialTimeout.
+// An endpoint which connections aren't ready within the timeout fails with EventEndpointDialFailed,
+// the connections dialed on demand by a lazy pool only time out with a blocking dial, e.g. grpc.WithBlock.
+func WithDialTimeout(timeout time.Duration) ServiceOption {
+	return func(s *service) {
+		if timeout > 0 {
+			s.dialTimeout.Store(int64(timeout))
+		}
+	}
+}
+
+// WithLazyDial defers dialing an endpoint until it is picked by the load balancing,
+// the connections of the endpoint are dialed one by one on demand up to the pool size.
+func WithLazyDial() ServiceOption {
+	return func(s *service) {
+		s.lazyDial.Store(true)
+	}
+}
+
+// WithMaxConnections bounds the total number of connections of the service across all endpoints,
+// an endpoint which can't get any connection from the budget is skipped by the load balancing.
+func WithMaxConnections(n int) ServiceOption {
+	return func(s *service) {
+		if n > 0 {
+			s.connBudget.Store(pool.NewBudget(n))
+		}
+	}
+}
+
+// WithIdleTimeout closes the connections of an endpoint which hasn't been used for timeout
+// and has no calls in flight, they are dialed again when the endpoint is picked.
+func WithIdleTimeout(timeout time.Duration) ServiceOption {
+	return func(s *service) {
+		if timeout <= 0 {
+			return
+		}
+		s.idleTimeout.Store(int64(timeout))
+		s.janitor.Do(func() {
+			go s.evictIdle()
+		})
+	}
+}
+
+func (s *service) newPool(endpoint *Endpoint) (*pool.Pool, error) {
+	opts := []pool.Option{pool.WithSize(int(s.poolSize.Load())), pool.WithDialTimeout(time.Duration(s.dialTimeout.Load()))}
+	if s.lazyDial.Load() || s.subsetSize.Load() > 0 {
+		opts = append(opts, pool.WithLazy())
+	}
+	if budget := s.connBudget.Load(); budget != nil {
+		opts = append(opts, pool.WithBudget(budget))
+	}
+	return pool.New(s.ctx, endpoint.PeerAddress, s.endpointDialOpts(endpoint.ID), opts...)
+}
+
+// waitPoolReady blocks until the dialed connections of the pool are ready, the pool is closed on failure.
+func (s *service) waitPoolReady(p *pool.Pool) error {
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(s.dialTimeout.Load()))
+	defer cancel()
+	if err := p.WaitReady(ctx); err != nil {
+		_ = p.Close()
+		return err
+	}
+	return nil
+}
+
+// idleCheckInterval returns the period of checking the idle connections, half of the idle timeout.
+func (s *service) idleCheckInterval() time.Duration {
+	return max(time.Duration(s.idleTimeout.Load())/2, minIdleCheckInterval)
+}
+
+// evictIdle checks the idle connections until the service is closed,
+// the period follows the idle timeout configured meanwhile.
+func (s *service) evictIdle() {
+	timer := time.NewTimer(s.idleCheckInterval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-timer.C:
+			timeout := time.Duration(s.idleTimeout.Load())
+			s.aliveConn.Range(func(_ string, p *pool.Pool) bool {
+				if p.Idle(timeout) {
+					p.Evict()
+				}
+				return true
+			})
+			timer.Reset(s.idleCheckInterval())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestService_DialUnreachable(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		events      = make(chan Event, 8)
+	)
+	defer cancel()
+
+	srv := NewService(ctx, testNaming, DefaultDialOpts...)
+	srv.Configure(WithDialTimeout(100*time.Millisecond), WithEventHandler(func(event Event) {
+		select {
+		case events <- event:
+		default:
+		}
+	}))
+	srv.AddEndpoints(NewEndpoint(testEndpointID(0), "127.0.0.1:1", 60, nil))
+
+	// the endpoint isn't ready until its connections are
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			switch event.Type {
+			case EventEndpointReady:
+				t.Fatal("the unreachable endpoint was reported ready")
+			case EventEndpointDialFailed:
+				if srv.ReadyCount() != 0 {
+					t.Fatalf("unexpected ready count: %d", srv.ReadyCount())
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("the dial of the unreachable endpoint didn't time out")
+		}
+	}
+}
+
+func TestService_ConfigurePoolingConcurrently(t *testing.T) {
+	ts := newTestServer(t, reply)
+	srv := newTestService(t, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			srv.Configure(
+				WithPoolSize(i%4+1),
+				WithDialTimeout(time.Second),
+				WithLazyDial(),
+				WithMaxConnections(100),
+			)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		srv.AddEndpoints(NewEndpoint(testEndpointID(i), ts.addr, 60, nil))
+	}
+	<-done
+}
+
+func TestService_IdleTimeoutReconfigured(t *testing.T) {
+	srv := newTestService(t, []*testServer{newTestServer(t, reply)}, WithIdleTimeout(time.Nanosecond))
+	if err := invokeTest(srv); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := srv.aliveConn.Load(testEndpointID(0))
+	eventually(t, func() bool { return p.Len() == 0 }, "the idle connections aren't evicted")
+
+	// the janitor follows the timeout configured later
+	srv.Configure(WithIdleTimeout(time.Hour))
+	time.Sleep(2 * minIdleCheckInterval)
+	if err := invokeTest(srv); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * minIdleCheckInterval)
+	if p.Len() == 0 {
+		t.Fatal("the connections are evicted before the idle timeout")
+	}
+}
+
+func TestService_DialConcurrency(t *testing.T) {
+	var (
+		ts     = newTestServer(t, reply)
+		srv    = newTestService(t, nil, WithDialConcurrency(2))
+		states = make(chan EndpointState, 16)
+	)
+	srv.Configure(WithEventHandler(func(event Event) {
+		if event.Type == EventEndpointReady {
+			states <- event.Endpoint.State()
+		}
+	}))
+
+	endpoints := make([]*Endpoint, 0, 16)
+	for i := 0; i < 16; i++ {
+		endpoints = append(endpoints, NewEndpoint(testEndpointID(i), ts.addr, 60, nil))
+	}
+	srv.AddEndpoints(endpoints...)
+	srv.dialMu.Lock()
+	workers := srv.dialWorkers
+	srv.dialMu.Unlock()
+	if workers > 2 {
+		t.Fatalf("unexpected dial workers: %d", workers)
+	}
+
+	// the endpoint is ready once EventEndpointReady is emitted
+	for i := 0; i < 16; i++ {
+		select {
+		case state := <-states:
+			if state != EndpointReady {
+				t.Fatalf("unexpected state of the ready endpoint: %s", state)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the endpoints aren't dialed")
+		}
+	}
+}
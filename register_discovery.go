@@ -3,86 +3,211 @@ package discovery
 import (
 	"context"
 	"github.com/RealFax/RedQueen/client"
+	"github.com/RealFax/red-discovery/internal/grammar"
 	"github.com/RealFax/red-discovery/internal/hack"
 	"github.com/RealFax/red-discovery/internal/maputil"
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"sync"
 	"time"
 )
 
-type ListenCallbackFunc func(ready bool, conn *grpc.ClientConn, wg *sync.WaitGroup)
+type ListenCallbackFunc func(ready bool, conn *grpc.ClientConn, ack ListenerAck)
 
 type DiscoveryAndRegister interface {
 	// ReleaseDiscovery cancel the discovery of a Naming.
 	ReleaseDiscovery(naming string)
 
 	// Discovery a Naming, will open a goroutine to achieve continuous discovery of Naming.
+	//
+	// A Naming ending with WildcardNaming discovers all the namings with the prefix, e.g. "pkg.billing.*",
+	// and WildcardNaming alone discovers the whole namespace. A Service is created for every Naming that appears.
+	//
+	// The Naming may be qualified to only discover the matching endpoints, see Identity,
+	// e.g. "orders@>=2.1, <3;env=prod". The Service is named after the qualified Naming.
 	Discovery(naming string) error
 
 	// Unregister one or more services using an Endpoint ID.
+	// If conflict detection is used for the naming, the endpoints claimed by another process are left to their owner.
 	Unregister(naming string, ids ...string) error
 
 	// Register one or more services with Naming.
 	Register(naming string, endpoints ...*Endpoint) error
 
+	// Grant a lease of ttl seconds, the endpoints registered with the lease are refreshed together.
+	// The registry keeps the TTL of a key in whole seconds, so the shortest lease is 1s,
+	// only its refreshes are scheduled with sub-second precision, see WithKeepAliveFraction.
+	Grant(ttl uint32) (LeaseID, error)
+
+	// RegisterLease registers one or more services with Naming and attaches them to the lease,
+	// the TTL of the endpoints is set to the TTL of the lease.
+	// A lease may hold the endpoints of several namings.
+	RegisterLease(lease LeaseID, naming string, endpoints ...*Endpoint) error
+
+	// KeepAlive refreshes the TTL of all the endpoints attached to the lease in one call.
+	// An endpoint claimed by another process since its key expired is detached from the lease,
+	// and its EndpointConflictError is returned, see UseConflictDetection.
+	KeepAlive(lease LeaseID) error
+
+	// TimeToLive returns the time left before the endpoints of the lease expire unless refreshed,
+	// it is negative once the lease is expired.
+	TimeToLive(lease LeaseID) (time.Duration, error)
+
+	// Revoke the lease and unregister all the endpoints attached to it.
+	Revoke(lease LeaseID) error
+
+	// WatchLease watches the endpoints attached to the lease until ctx is done or the lease is revoked,
+	// when one of them is deleted or modified by others the policy is applied and the handler is called.
+	WatchLease(ctx context.Context, lease LeaseID, policy SelfWatchPolicy, handler LeaseEventHandler) error
+
 	// UseListener
 	//
 	// Monitors whether a Naming is available.
 	// When ready is true, it means that there are available services for this Naming.
 	// When it is false, it means that there are no services available under the Naming.
-	UseListener(naming string, callback ListenCallbackFunc) (string, error)
+	//
+	// Every listener has its own queue, the callbacks are called in order on a separate goroutine,
+	// a panicking callback is recovered. A slow listener doesn't block the discovery unless ListenerBlock is used.
+	UseListener(naming string, callback ListenCallbackFunc, opts ...ListenerOption) (string, error)
 
 	// DestroyListener cancel listening to Naming based on the ListenerID returned by UseListener.
 	DestroyListener(naming, listenerID string)
+
+	// UseGlobalListener monitors whether any discovered Naming is available,
+	// including the namings discovered by a wildcard. It doesn't require Discovery first.
+	//
+	// The default policy is ListenerDropOldest with a buffer of 256 state changes.
+	// Its stats are returned by ListenerStats with WildcardNaming.
+	UseGlobalListener(callback GlobalListenCallbackFunc, opts ...ListenerOption) string
+
+	// DestroyGlobalListener cancel the listener returned by UseGlobalListener.
+	DestroyGlobalListener(listenerID string)
+
+	// ListenerStats returns the delivery stats of a listener.
+	ListenerStats(naming, listenerID string) (ListenerStats, bool)
+
+	// UseMetadataSchema validates the metadata of the endpoints registered under a Naming by Register,
+	// a nil schema removes the validation.
+	UseMetadataSchema(naming string, schema *MetadataSchema)
+
+	// UseConflictDetection claims the endpoints registered under a Naming by Register,
+	// an endpoint ID owned by another process, or a PeerAddress registered under another ID,
+	// fails the registration with an EndpointConflictError, see SetOwnerToken.
+	// An endpoint claimed by another process after its registration is detected by WithSelfWatch.
+	UseConflictDetection(naming string, enabled bool)
+
+	// UseServiceOptions set the options of a Naming,
+	// they are applied to the existing Service and to the Service created by Discovery or Register.
+	UseServiceOptions(naming string, opts ...ServiceOption)
 }
 
 type discoveryAndRegister struct {
 	ctx       context.Context
 	dialOpts  []grpc.DialOption
 	kvClient  client.KvClient
-	services  *maputil.Map[string, Service]                                  // map<naming, Service>
-	discovery *maputil.Map[string, context.CancelFunc]                       // map<naming, discoverySignal>
-	listener  *maputil.Map[string, *maputil.Map[string, ListenCallbackFunc]] // map<string, map<string, ListenCallbackFunc>>
+	services  *maputil.Map[string, Service]                              // map<naming, Service>
+	discovery *maputil.Map[string, context.CancelFunc]                   // map<naming, discoverySignal>
+	listener  *maputil.Map[string, *maputil.Map[string, *listenerQueue]] // map<naming, map<listenerID, *listenerQueue>>
+	options   *maputil.Map[string, []ServiceOption]                      // map<naming, []ServiceOption>
+	schemas   *maputil.Map[string, *MetadataSchema]                      // map<naming, *MetadataSchema>
+	leases    *maputil.Map[LeaseID, *lease]                              // map<leaseID, *lease>
+	conflicts *maputil.Map[string, struct{}]                             // set<naming>
+
+	servicesMu sync.Mutex // serializes the creation of services
 }
 
-func (r *discoveryAndRegister) notifyStateChange(srv Service) {
-	childListener, found := r.listener.Load(srv.Naming())
-	if !found {
-		return
+func (r *discoveryAndRegister) newService(naming string) Service {
+	srv := NewService(r.ctx, naming, r.dialOpts...)
+
+	// endpoints are dialed in background, notify the listeners when they are done
+	srv.Configure(WithEventHandler(func(event Event) {
+		if event.Type != EventEndpointReady {
+			r.notifyStateChange(srv)
+		}
+	}))
+	if opts, ok := r.options.Load(naming); ok {
+		srv.Configure(opts...)
 	}
+	return srv
+}
 
+func (r *discoveryAndRegister) notifyStateChange(srv Service) {
 	var (
-		wg      = &sync.WaitGroup{}
-		state   = srv.Alive()
-		conn, _ = srv.NextAliveConn()
+		childListener, found = r.listener.Load(srv.Naming())
+		globalListener, _    = r.listener.Load(WildcardNaming)
 	)
+	if !found && globalListener == nil {
+		return
+	}
+
+	// the connection is looked up without picking, a pick spends the rate tokens and the probes of the calls
+	event := listenerEvent{naming: srv.Naming()}
+	if s, ok := srv.(*service); ok {
+		event.conn = s.readyConn()
+	}
+	event.ready = event.conn != nil
 
-	childListener.Range(func(_ string, fc ListenCallbackFunc) bool {
-		wg.Add(1)
-		fc(state, conn, wg)
+	push := func(_ string, queue *listenerQueue) bool {
+		queue.Push(event)
 		return true
-	})
+	}
+	if found {
+		childListener.Range(push)
+	}
+	if globalListener != nil {
+		globalListener.Range(push)
+	}
+}
 
-	go func() {
-		wg.Wait()
-	}()
+// discovered reports whether naming is discovered by itself or by a wildcard.
+func (r *discoveryAndRegister) discovered(naming string) bool {
+	if r.discovery.Exist(naming) {
+		return true
+	}
+
+	found := false
+	r.discovery.Range(func(pattern string, _ context.CancelFunc) bool {
+		sel, err := parseSelector(pattern)
+		found = err == nil && sel.covers(naming)
+		return !found
+	})
+	return found
 }
 
-func (r *discoveryAndRegister) discoveryDaemon(namespace *string, naming string) {
-	// double-check-area naming discovery status
-	ctx, cancel := context.WithCancel(r.ctx)
+func (r *discoveryAndRegister) useListener(naming string, queue *listenerQueue) string {
+	var (
+		found         bool
+		childListener *maputil.Map[string, *listenerQueue]
+	)
+	if childListener, found = r.listener.Load(naming); !found {
+		childListener, found = r.listener.LoadOrStore(naming, maputil.New[string, *listenerQueue]())
+		if !found {
+			childListener, _ = r.listener.Load(naming)
+		}
+	}
 
-	// release naming discovery lock
-	// defer cancel()
+	listenerID := uuid.NewString()
+	childListener.Store(listenerID, queue)
+	return listenerID
+}
 
-	// discovery has existed
-	if _, ok := r.discovery.LoadOrStore(naming, cancel); ok {
+func (r *discoveryAndRegister) destroyListener(naming, listenerID string) {
+	childListener, found := r.listener.Load(naming)
+	if !found {
 		return
 	}
 
+	if queue, ok := childListener.LoadAndDelete(listenerID); ok {
+		queue.Close()
+	}
+}
+
+func (r *discoveryAndRegister) discoveryDaemon(ctx context.Context, namespace *string, sel selector, prefix string) {
 	watcher := client.NewWatcher(
-		hack.String2Bytes(naming),
+		hack.String2Bytes(prefix),
 		client.WatchWithPrefix(),
 		client.WatchWithNamespace(namespace),
 	)
@@ -91,7 +216,7 @@ func (r *discoveryAndRegister) discoveryDaemon(namespace *string, naming string)
 	go func() {
 		defer func() {
 			// prevent variable race
-			_cancel, ok := r.discovery.LoadAndDelete(naming)
+			_cancel, ok := r.discovery.LoadAndDelete(sel.raw)
 			if !ok {
 				return
 			}
@@ -105,11 +230,11 @@ func (r *discoveryAndRegister) discoveryDaemon(namespace *string, naming string)
 
 	// get watcher notify
 	var (
-		ok                         bool
 		err                        error
 		endpointNaming, endpointID string
 		value                      *client.WatchValue
 		endpoint                   *Endpoint
+		ok                         bool
 		srv                        Service
 		notify, _                  = watcher.Notify()
 	)
@@ -126,28 +251,40 @@ func (r *discoveryAndRegister) discoveryDaemon(namespace *string, naming string)
 				continue
 			}
 
-			// trying load exist service, if not found and value not nil then init service
-			if srv, ok = r.services.Load(endpointNaming); !ok {
-				srv, ok = r.services.LoadOrStore(endpointNaming, NewService(r.ctx, endpointNaming, r.dialOpts...))
-				if !ok {
-					srv, _ = r.services.Load(endpointNaming)
-				}
+			// a wildcard prefix of the legacy layout isn't terminated
+			if !grammar.Match(sel.naming, endpointNaming) {
+				continue
 			}
 
-			// deleted
-			if value.Value == nil {
-				srv.DelEndpoints(endpointID)
+			if value.Value != nil {
+				if endpoint, err = ParseEndpoint(value.Value); err != nil {
+					continue
+				}
+			}
 
-				// notify naming listeners
-				r.notifyStateChange(srv)
+			// in the dual layout, the endpoint is kept while its key of the other layout exists
+			if value.Value == nil && r.dualKeyExists(ctx, namespace, hack.Bytes2String(value.Key)) {
 				continue
 			}
 
-			if endpoint, err = ParseEndpoint(value.Value); err != nil {
+			// deleted, or no longer matches the qualifiers, e.g. the version is changed
+			if value.Value == nil || !sel.match(endpoint) {
+				if srv, ok = r.services.Load(sel.service(endpointNaming)); !ok {
+					continue
+				}
+				if _, ok = srv.Endpoint(endpointID); ok {
+					srv.DelEndpoints(endpointID)
+
+					// notify naming listeners
+					r.notifyStateChange(srv)
+				}
 				continue
 			}
 
-			endpoint.lastUpdated = value.Timestamp
+			// trying load exist service, if not found then init service
+			srv = r.loadService(sel.service(endpointNaming))
+
+			endpoint.touch(value.Timestamp)
 			endpoint.SetTTL(value.TTL)
 
 			// update endpoint
@@ -159,6 +296,16 @@ func (r *discoveryAndRegister) discoveryDaemon(namespace *string, naming string)
 	}
 }
 
+// dualKeyExists reports whether the key of the other layout exists, or can't be checked.
+func (r *discoveryAndRegister) dualKeyExists(ctx context.Context, namespace *string, key string) bool {
+	other, ok := dualKey(key)
+	if !ok {
+		return false
+	}
+	_, err := r.kvClient.Get(ctx, hack.String2Bytes(other), namespace)
+	return status.Code(err) != codes.NotFound
+}
+
 func (r *discoveryAndRegister) ReleaseDiscovery(naming string) {
 	cancel, ok := r.discovery.LoadAndDelete(naming)
 	if !ok {
@@ -168,61 +315,133 @@ func (r *discoveryAndRegister) ReleaseDiscovery(naming string) {
 }
 
 func (r *discoveryAndRegister) Discovery(naming string) error {
+	sel, err := parseSelector(naming)
+	if err != nil {
+		return err
+	}
+	_, wildcard := namingPrefix(sel.naming)
+
 	// check discovery status
 	if exist := r.discovery.Exist(naming); exist {
-		if !r.services.Exist(naming) {
-			r.services.LoadOrStore(naming, NewService(r.ctx, naming, r.dialOpts...))
+		if !wildcard {
+			r.loadService(naming)
 		}
 		return ErrDiscoveryHasExist
 	}
 
-	go r.discoveryDaemon(namespace.Load(), naming)
+	// take the discovery of naming, a concurrent Discovery returns ErrDiscoveryHasExist
+	ctx, cancel := context.WithCancel(r.ctx)
+	if _, loaded := r.discovery.LoadOrStore(naming, cancel); loaded {
+		cancel()
+		return ErrDiscoveryHasExist
+	}
 
-	values, err := r.kvClient.PrefixScan(
-		r.ctx,
-		hack.String2Bytes(naming),
-		0,
-		MaxEndpointSize,
-		nil,
-		namespace.Load(),
-	)
-	if err != nil {
-		return nil
+	prefixes := keyPrefixes(sel.naming)
+	for _, prefix := range prefixes {
+		go r.discoveryDaemon(ctx, namespace.Load(), sel, prefix)
 	}
 
-	srv, ok := r.services.Load(naming)
-	if !ok {
-		srv = NewService(r.ctx, naming, r.dialOpts...)
-		r.services.Store(naming, srv)
+	if !wildcard {
+		r.loadService(naming)
 	}
 
-	var endpoint *Endpoint
-	for _, value := range values {
-		if endpoint, err = ParseEndpoint(value.Data); err != nil {
-			return err
+	var scanned int
+	for _, prefix := range prefixes {
+		// the endpoints are added to their service at once, the subset is selected once per scan
+		batches := make(map[string][]*Endpoint)
+		n, err := scanPrefix(r.ctx, r.kvClient, prefix, func(value *client.Value) bool {
+			endpoint, err := ParseEndpoint(value.Data)
+			if err != nil {
+				// a wildcard may match keys which aren't endpoints
+				return true
+			}
+
+			// the scan doesn't return the keys, the naming of an endpoint is only known
+			// if it is recorded by Register, the others are added by their next update.
+			endpointNaming := endpoint.Naming
+			if endpointNaming == "" {
+				if wildcard {
+					return true
+				}
+				endpointNaming = sel.naming
+			}
+			if !grammar.Match(sel.naming, endpointNaming) || !sel.match(endpoint) {
+				return true
+			}
+
+			endpoint.SetTTL(value.TTL)
+			endpoint.touch(time.Now().UnixMilli())
+			name := sel.service(endpointNaming)
+			batches[name] = append(batches[name], endpoint)
+			return true
+		})
+		for name, batch := range batches {
+			r.loadService(name).AddEndpoints(batch...)
+		}
+		scanned += n
+
+		switch {
+		case err == nil:
+		case scanned == 0:
+			// nothing discovered, allow to retry
+			r.ReleaseDiscovery(naming)
+			return errors.Wrap(err, "sdr: Discovery")
+		default:
+			// the watch keeps running, the rest endpoints are added by their next update
+			return errors.Wrapf(ErrDiscoveryIncomplete, "%d endpoints scanned, cause: %s", scanned, err)
 		}
-		endpoint.SetTTL(value.TTL)
-		endpoint.lastUpdated = time.Now().UnixMilli()
-		srv.AddEndpoints(endpoint)
 	}
-
 	return nil
 }
 
+// loadService returns the Service of naming, it is created if not exists.
+// The Service is only built on a miss, a discarded Service would leave its goroutines behind.
+func (r *discoveryAndRegister) loadService(naming string) Service {
+	if srv, ok := r.services.Load(naming); ok {
+		return srv
+	}
+
+	r.servicesMu.Lock()
+	defer r.servicesMu.Unlock()
+	if srv, ok := r.services.Load(naming); ok {
+		return srv
+	}
+	srv := r.newService(naming)
+	r.services.Store(naming, srv)
+	return srv
+}
+
 func (r *discoveryAndRegister) Unregister(naming string, ids ...string) (err error) {
 	srv, ok := r.services.Load(naming)
 	if !ok {
 		return ErrServiceNotExist
 	}
 
+	owned := r.conflicts.Exist(naming)
 	for _, id := range ids {
-		if err = r.kvClient.Delete(
-			r.ctx,
-			hack.String2Bytes(srv.WithEndpointNaming(id)),
-			namespace.Load(),
-		); err != nil {
-			return
+		// an unregistered endpoint is no longer refreshed by its lease,
+		// detached before deleted, the self watch doesn't write it back
+		r.leases.Range(func(_ LeaseID, l *lease) bool {
+			l.detach(naming, id)
+			return true
+		})
+
+		// the endpoint claimed by another process is left to its owner
+		if owned && r.claimedByOther(naming, id) {
+			continue
+		}
+
+		for i, key := range endpointKeys(naming, id) {
+			// the secondary key may not exist
+			if err = r.kvClient.Delete(
+				r.ctx,
+				hack.String2Bytes(key),
+				namespace.Load(),
+			); err != nil && i == 0 {
+				return
+			}
 		}
+		err = nil
 
 		// del endpoint from service
 		srv.DelEndpoints(id)
@@ -231,65 +450,140 @@ func (r *discoveryAndRegister) Unregister(naming string, ids ...string) (err err
 	return
 }
 
-func (r *discoveryAndRegister) Register(naming string, endpoints ...*Endpoint) (err error) {
-	srv, ok := r.services.Load(naming)
-	if !ok {
-		srv = NewService(r.ctx, naming, r.dialOpts...)
-		r.services.Store(naming, srv)
+func (r *discoveryAndRegister) Register(naming string, endpoints ...*Endpoint) error {
+	_, err := r.register(naming, endpoints...)
+	return err
+}
+
+// register returns the endpoints which are written to the registry.
+func (r *discoveryAndRegister) register(naming string, endpoints ...*Endpoint) (registered []*Endpoint, err error) {
+	if err = ValidateNaming(naming); err != nil {
+		return
 	}
+	for _, endpoint := range endpoints {
+		if err = ValidateEndpointID(endpoint.ID); err != nil {
+			return nil, errors.Wrap(err, endpoint.ID)
+		}
+		identity := endpoint.Identity()
+		identity.Naming = naming
+		if err = identity.Validate(); err != nil {
+			return nil, errors.Wrap(err, endpoint.ID)
+		}
+		if schema, ok := r.schemas.Load(naming); ok {
+			if err = validateMetadata(schema, endpoint); err != nil {
+				return nil, errors.Wrap(err, endpoint.ID)
+			}
+		}
+	}
+
+	var addresses map[string][]*Endpoint
+	if r.conflicts.Exist(naming) {
+		if addresses, err = r.registeredAddresses(naming); err != nil {
+			return nil, errors.Wrap(err, "sdr: Register")
+		}
+	}
+
+	srv := r.loadService(naming)
 
-	var endpointOut []byte
 	// registered endpoints
 	for _, endpoint := range endpoints {
-		endpointOut, _ = endpoint.Marshal()
-		if err = r.kvClient.Set(
-			r.ctx,
-			hack.String2Bytes(endpoint.WithNaming(naming)),
-			endpointOut,
-			endpoint.TTL(),
-			namespace.Load(),
-		); err != nil {
+		endpoint.Naming = naming
+		if werr := r.writeEndpoint(naming, endpoint, addresses); werr != nil {
+			// the first failure is returned, e.g. an EndpointConflictError
+			if err == nil {
+				err = errors.Wrap(werr, endpoint.ID)
+			}
 			continue
 		}
 
-		// add endpoint to service
-		srv.AddEndpoints(endpoint)
+		registered = append(registered, endpoint)
+	}
+
+	// add the endpoints to service at once
+	if len(registered) != 0 {
+		srv.AddEndpoints(registered...)
 	}
+
+	// a leased endpoint registered again is written back by its lease as registered last
+	r.leases.Range(func(_ LeaseID, l *lease) bool {
+		l.update(registered...)
+		return true
+	})
 	return
 }
 
-func (r *discoveryAndRegister) UseListener(naming string, callback ListenCallbackFunc) (string, error) {
-	if exist := r.discovery.Exist(naming); !exist {
-		return "", ErrShouldDiscoveryFirst
+// setEndpoint writes the endpoint to all the keys of the key layout.
+func (r *discoveryAndRegister) setEndpoint(naming, id string, value []byte, ttl uint32) error {
+	for _, key := range endpointKeys(naming, id) {
+		if err := r.kvClient.Set(
+			r.ctx,
+			hack.String2Bytes(key),
+			value,
+			ttl,
+			namespace.Load(),
+		); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	var (
-		found         bool
-		childListener *maputil.Map[string, ListenCallbackFunc]
-	)
-	if childListener, found = r.listener.Load(naming); !found {
-		childListener, found = r.listener.LoadOrStore(naming, maputil.New[string, ListenCallbackFunc]())
-		if !found {
-			childListener, _ = r.listener.Load(naming)
-		}
+func (r *discoveryAndRegister) UseListener(naming string, callback ListenCallbackFunc, opts ...ListenerOption) (string, error) {
+	if !r.discovered(naming) {
+		return "", ErrShouldDiscoveryFirst
 	}
 
-	listenerID := uuid.NewString()
-	childListener.Store(listenerID, callback)
-	return listenerID, nil
+	opts = append([]ListenerOption{WithListenerPolicy(ListenerCoalesce)}, opts...)
+	return r.useListener(naming, newListenerQueue(
+		r.ctx,
+		func(_ string, ready bool, conn *grpc.ClientConn, ack ListenerAck) {
+			callback(ready, conn, ack)
+		},
+		opts...,
+	)), nil
 }
 
 func (r *discoveryAndRegister) DestroyListener(naming, listenerID string) {
-	if exist := r.discovery.Exist(naming); !exist {
+	if !r.discovered(naming) {
 		return
 	}
+	r.destroyListener(naming, listenerID)
+}
+
+func (r *discoveryAndRegister) UseGlobalListener(callback GlobalListenCallbackFunc, opts ...ListenerOption) string {
+	opts = append([]ListenerOption{WithListenerPolicy(ListenerDropOldest), WithListenerBuffer(256)}, opts...)
+	return r.useListener(WildcardNaming, newListenerQueue(r.ctx, callback, opts...))
+}
 
+func (r *discoveryAndRegister) DestroyGlobalListener(listenerID string) {
+	r.destroyListener(WildcardNaming, listenerID)
+}
+
+func (r *discoveryAndRegister) ListenerStats(naming, listenerID string) (ListenerStats, bool) {
 	childListener, found := r.listener.Load(naming)
 	if !found {
+		return ListenerStats{}, false
+	}
+	queue, ok := childListener.Load(listenerID)
+	if !ok {
+		return ListenerStats{}, false
+	}
+	return queue.Stats(), true
+}
+
+func (r *discoveryAndRegister) UseMetadataSchema(naming string, schema *MetadataSchema) {
+	if schema == nil {
+		r.schemas.Delete(naming)
 		return
 	}
+	r.schemas.Store(naming, schema)
+}
 
-	childListener.Delete(listenerID)
+func (r *discoveryAndRegister) UseServiceOptions(naming string, opts ...ServiceOption) {
+	r.options.Store(naming, opts)
+	if srv, ok := r.services.Load(naming); ok {
+		srv.Configure(opts...)
+	}
 }
 
 func NewDiscoveryAndRegister(
@@ -304,6 +598,10 @@ func NewDiscoveryAndRegister(
 		kvClient:  c,
 		services:  services,
 		discovery: maputil.New[string, context.CancelFunc](),
-		listener:  maputil.New[string, *maputil.Map[string, ListenCallbackFunc]](),
+		listener:  maputil.New[string, *maputil.Map[string, *listenerQueue]](),
+		options:   maputil.New[string, []ServiceOption](),
+		schemas:   maputil.New[string, *MetadataSchema](),
+		leases:    maputil.New[LeaseID, *lease](),
+		conflicts: maputil.New[string, struct{}](),
 	}
 }
@@ -1,13 +1,23 @@
 package discovery_test
 
 import (
+	"context"
 	discovery "github.com/RealFax/red-discovery"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc"
-	"sync"
+	"os"
+	"sync/atomic"
+	"time"
 )
 
 func ExampleDiscoveryAndRegister_Discovery() {
+	// scan the registry 1024 endpoints at a time
+	discovery.SetScanPageSize(1024)
+
 	if err := client.Discovery(naming); err != nil {
+		if errors.Is(err, discovery.ErrDiscoveryIncomplete) {
+			// part of the endpoints are discovered, the rest are added by their next update
+		}
 		// handle discovery error
 	}
 }
@@ -33,9 +43,9 @@ func ExampleDiscoveryAndRegister_Register() {
 }
 
 func ExampleDiscoveryAndRegister_UseListener() {
-	listenerID, err := client.UseListener(naming, func(ready bool, conn *grpc.ClientConn, wg *sync.WaitGroup) {
+	listenerID, err := client.UseListener(naming, func(ready bool, conn *grpc.ClientConn, ack discovery.ListenerAck) {
 		// handle listener callback
-		// you should defer called wg.Done
+		// you should defer called ack.Done
 	})
 	if err != nil {
 		// handle UseListener error
@@ -44,3 +54,302 @@ func ExampleDiscoveryAndRegister_UseListener() {
 	// destroy listener by listener id
 	client.DestroyListener(naming, listenerID)
 }
+
+func ExampleDiscoveryAndRegister_UseServiceOptions() {
+	// trip the circuit of an endpoint after 3 consecutive failures, probe it again after 10 seconds
+	client.UseServiceOptions(naming, discovery.WithCircuitBreaker(discovery.CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         time.Second * 10,
+		HalfOpenProbes:      1,
+	}))
+
+	if err := client.Discovery(naming); err != nil {
+		// handle discovery error
+	}
+}
+
+func ExampleEndpoint_PutLimits() {
+	endpoint := discovery.NewEndpoint("node-1", "localhost:8080", 1200, nil)
+
+	// advertise the capacity of the endpoint to every discoverer
+	if err := endpoint.PutLimits(discovery.Limits{
+		Rate:          1000,
+		Burst:         100,
+		MaxConcurrent: 64,
+	}); err != nil {
+		// handle error
+	}
+
+	if err := client.Register(naming, endpoint); err != nil {
+		// handler register error
+	}
+
+	// discoverer side, honour the published limits and limit the whole service
+	client.UseServiceOptions(
+		naming,
+		discovery.WithPublishedLimits(),
+		discovery.WithLimits(discovery.Limits{Rate: 5000, MaxConcurrent: 256}),
+	)
+}
+
+func ExampleWithAdaptiveLimit() {
+	// learn the concurrency each endpoint can sustain, overloaded endpoints get less traffic
+	client.UseServiceOptions(naming, discovery.WithAdaptiveLimit(discovery.DefaultAdaptiveLimitConfig))
+
+	if err := client.Discovery(naming); err != nil {
+		// handle discovery error
+	}
+}
+
+func ExampleWithLazyDial() {
+	// dial on demand, bound the connections of the service and close the idle ones
+	client.UseServiceOptions(
+		naming,
+		discovery.WithLazyDial(),
+		discovery.WithPoolSize(4),
+		discovery.WithMaxConnections(64),
+		discovery.WithIdleTimeout(5*time.Minute),
+	)
+
+	if err := client.Discovery(naming); err != nil {
+		// handle discovery error
+	}
+}
+
+func ExampleWithSubset() {
+	// a stable identity keeps the same subset across restarts
+	hostname, _ := os.Hostname()
+	discovery.SetClientID(hostname)
+
+	// only connect to 8 endpoints of the naming, however many are registered
+	client.UseServiceOptions(naming, discovery.WithSubset(8))
+
+	if err := client.Discovery(naming); err != nil {
+		// handle discovery error
+	}
+}
+
+func ExampleWithEventHandler() {
+	// endpoints are dialed in background, at most 4 at once
+	client.UseServiceOptions(
+		naming,
+		discovery.WithDialConcurrency(4),
+		discovery.WithDialTimeout(3*time.Second),
+		discovery.WithEventHandler(func(event discovery.Event) {
+			switch event.Type {
+			case discovery.EventEndpointReady:
+				// endpoint can be picked
+			case discovery.EventEndpointDialFailed:
+				// handle event.Err
+			case discovery.EventEndpointUpdated:
+				// event.Endpoint carries the updated metadata
+			}
+		}),
+	)
+
+	if err := client.Discovery(naming); err != nil {
+		// handle discovery error
+	}
+}
+
+func ExampleDiscoveryAndRegister_ListenerStats() {
+	// deliver every state change, drop the oldest ones if the listener falls behind 64 changes
+	listenerID, err := client.UseListener(naming, func(ready bool, conn *grpc.ClientConn, ack discovery.ListenerAck) {
+		defer ack.Done()
+		// handle listener callback
+	},
+		discovery.WithListenerBuffer(64),
+		discovery.WithListenerPolicy(discovery.ListenerDropOldest),
+		discovery.WithSlowListenerThreshold(100*time.Millisecond),
+	)
+	if err != nil {
+		// handle UseListener error
+	}
+
+	if stats, ok := client.ListenerStats(naming, listenerID); ok && stats.Slow != 0 {
+		// the listener is too slow, stats.Dropped state changes have been dropped
+	}
+}
+
+func ExampleDiscoveryAndRegister_UseGlobalListener() {
+	// discovery every naming of the billing domain, services are created as they appear
+	if err := client.Discovery("pkg.billing." + discovery.WildcardNaming); err != nil {
+		// handle discovery error
+	}
+
+	listenerID := client.UseGlobalListener(func(naming string, ready bool, conn *grpc.ClientConn, ack discovery.ListenerAck) {
+		defer ack.Done()
+		// route to naming
+	})
+
+	// destroy listener by listener id
+	client.DestroyGlobalListener(listenerID)
+}
+
+func ExampleSetKeyLayout() {
+	// once every client reads the "services/<naming>/<id>" layout, stop writing the legacy keys
+	discovery.SetKeyLayout(discovery.KeyLayoutServices)
+
+	if err := discovery.ValidateNaming("pkg.billing.invoice"); err != nil {
+		// handle invalid naming
+	}
+}
+
+func ExampleIdentity() {
+	// register v2.1.0 of orders in production
+	endpoint := discovery.NewEndpoint("orders-1", "10.0.0.1:8080", 30, nil)
+	endpoint.Version = "v2.1.0"
+	endpoint.Env = "prod"
+	if err := client.Register("orders", endpoint); err != nil {
+		// handle register error
+	}
+
+	// only discover the compatible production endpoints
+	selector := "orders@>=2.1, <3;env=prod"
+	if err := client.Discovery(selector); err != nil {
+		// handle discovery error
+	}
+	srv, ok := client.Service(selector)
+	if !ok {
+		return
+	}
+	_ = srv
+}
+
+func ExampleDiscoveryAndRegister_UseMetadataSchema() {
+	minWeight, maxWeight := 0.0, 100.0
+	client.UseMetadataSchema(naming, &discovery.MetadataSchema{
+		Required: []string{"zone", "weight"},
+		Properties: map[string]discovery.MetadataProperty{
+			"zone":     {Type: discovery.MetadataString},
+			"weight":   {Type: discovery.MetadataInteger, Minimum: &minWeight, Maximum: &maxWeight},
+			"protocol": {Type: discovery.MetadataString, Enum: []any{"grpc", "http"}},
+			"gpu":      {Type: discovery.MetadataBoolean},
+		},
+	})
+
+	endpoint := discovery.NewEndpoint("node-1", "localhost:8080", 30, nil)
+	_ = endpoint.PutMetadata(&discovery.StandardMetadata{
+		Zone:     "us-east-1",
+		Weight:   10,
+		Protocol: "grpc",
+		Extra:    map[string]any{"gpu": true},
+	})
+
+	if err := client.Register(naming, endpoint); errors.Is(err, discovery.ErrInvalidMetadata) {
+		// handle invalid metadata
+	}
+}
+
+func ExampleEndpoint_DecodeMetadata() {
+	srv, ok := client.Service(naming)
+	if !ok {
+		return
+	}
+
+	srv.RangeEndpoints(func(endpoint *discovery.Endpoint) bool {
+		var md discovery.StandardMetadata
+		if err := endpoint.DecodeMetadata(&md); err != nil {
+			// handle invalid metadata
+			return true
+		}
+		_ = md.Zone
+		return true
+	})
+}
+
+func ExampleDiscoveryAndRegister_RegisterLease() {
+	lease, err := client.Grant(30)
+	if err != nil {
+		// handle grant error
+	}
+
+	// attach the endpoints of every naming of the process to one lease
+	if err = client.RegisterLease(lease, "orders", discovery.NewEndpoint("orders-1", "10.0.0.1:8080", 0, nil)); err != nil {
+		// handle register error
+	}
+	if err = client.RegisterLease(lease, "billing", discovery.NewEndpoint("billing-1", "10.0.0.1:8081", 0, nil)); err != nil {
+		// handle register error
+	}
+
+	// refresh all of them in one call, well before the TTL is reached
+	if err = client.KeepAlive(lease); err != nil {
+		// handle keepalive error
+	}
+
+	// unregister all of them on shutdown
+	if err = client.Revoke(lease); err != nil {
+		// handle revoke error
+	}
+}
+
+func ExampleKeepAliveLease() {
+	var ready atomic.Bool
+
+	lease, err := client.Grant(3)
+	if err != nil {
+		// handle grant error
+	}
+	if err = client.RegisterLease(lease, naming, discovery.NewEndpoint("node-1", "localhost:8080", 0, nil)); err != nil {
+		// handle register error
+	}
+	ready.Store(true)
+
+	// refresh about every second, retry failed refreshes before the lease expires
+	if err = discovery.KeepAliveLease(context.Background(), client, lease,
+		discovery.WithKeepAliveFraction(1.0/3),
+		discovery.WithKeepAliveBackoff(50*time.Millisecond, time.Second),
+		discovery.WithLeaseEventHandler(func(event discovery.LeaseEvent) {
+			switch event.Type {
+			case discovery.LeaseLost:
+				// stop receiving traffic until the lease is refreshed again
+				ready.Store(false)
+			case discovery.LeaseRecovered:
+				ready.Store(true)
+			}
+		}),
+	); err != nil {
+		// handle keepalive error
+	}
+}
+
+func ExampleWithSelfWatch() {
+	lease, err := client.Grant(30)
+	if err != nil {
+		// handle grant error
+	}
+	if err = client.RegisterLease(lease, naming, discovery.NewEndpoint("node-1", "localhost:8080", 0, nil)); err != nil {
+		// handle register error
+	}
+
+	// an endpoint unregistered by the operator stays unregistered,
+	// an endpoint lost by restoring the registry is registered again
+	if err = discovery.KeepAliveLease(context.Background(), client, lease,
+		discovery.WithSelfWatch(discovery.SelfWatchRespectDeletion),
+		discovery.WithLeaseEventHandler(func(event discovery.LeaseEvent) {
+			switch event.Type {
+			case discovery.LeaseEndpointDeleted:
+				// event.Endpoint is no longer registered
+			case discovery.LeaseEndpointRestored:
+				// event.Endpoint is written back
+			}
+		}),
+	); err != nil {
+		// handle keepalive error
+	}
+}
+
+func ExampleDiscoveryAndRegister_UseConflictDetection() {
+	// a stable token reclaims the endpoints of this host at once after a restart
+	hostname, _ := os.Hostname()
+	discovery.SetOwnerToken(hostname)
+
+	client.UseConflictDetection(naming, true)
+
+	err := client.Register(naming, discovery.NewEndpoint("node-1", "localhost:8080", 30, nil))
+	var conflict *discovery.EndpointConflictError
+	if errors.As(err, &conflict) {
+		// conflict.Conflict is the endpoint registered by another process,
+		// with the same ID, or with the same PeerAddress under another ID
+	}
+}
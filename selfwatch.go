@@ -0,0 +1,217 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"github.com/RealFax/RedQueen/client"
+	"github.com/RealFax/red-discovery/internal/backoff"
+	"github.com/RealFax/red-discovery/internal/hack"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// SelfWatchPolicy decides what WatchLease does when an endpoint of the lease is deleted or modified by others,
+// e.g. by the CLI or by restoring the registry from a snapshot.
+type SelfWatchPolicy int
+
+const (
+	// SelfWatchReregister writes the endpoint back as soon as it is deleted or modified.
+	SelfWatchReregister SelfWatchPolicy = iota
+
+	// SelfWatchRespectDeletion removes a deleted endpoint from the lease, so it is no longer refreshed,
+	// a modified endpoint is written back.
+	SelfWatchRespectDeletion
+
+	// SelfWatchAlert only emits the events, the endpoint is written back by the next KeepAlive.
+	SelfWatchAlert
+)
+
+// selfWatch of a lease, it is stopped when the lease is revoked.
+type selfWatch struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	policy  SelfWatchPolicy
+	handler LeaseEventHandler
+	namings map[string]struct{} // guarded by the lease mutex
+}
+
+func (w *selfWatch) emit(event LeaseEvent) {
+	if w.handler != nil {
+		w.handler(event)
+	}
+}
+
+func (r *discoveryAndRegister) WatchLease(ctx context.Context, id LeaseID, policy SelfWatchPolicy, handler LeaseEventHandler) error {
+	l, ok := r.leases.Load(id)
+	if !ok {
+		return ErrLeaseNotFound
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &selfWatch{
+		ctx:    ctx,
+		cancel: cancel,
+		policy: policy,
+		handler: func(event LeaseEvent) {
+			event.Lease = id
+			if handler != nil {
+				handler(event)
+			}
+		},
+		namings: make(map[string]struct{}),
+	}
+
+	l.mu.Lock()
+	if l.watch != nil {
+		l.watch.cancel()
+	}
+	l.watch = w
+	namings := make(map[string]struct{})
+	for _, endpoint := range l.endpoints {
+		namings[endpoint.Naming] = struct{}{}
+	}
+	l.mu.Unlock()
+
+	for naming := range namings {
+		r.startSelfWatch(l, w, naming)
+	}
+	return nil
+}
+
+// startSelfWatch watches the endpoints of the lease under naming, once per naming.
+func (r *discoveryAndRegister) startSelfWatch(l *lease, w *selfWatch, naming string) {
+	l.mu.Lock()
+	_, ok := w.namings[naming]
+	w.namings[naming] = struct{}{}
+	l.mu.Unlock()
+	if !ok {
+		go r.selfWatchDaemon(l, w, naming)
+	}
+}
+
+func (r *discoveryAndRegister) selfWatchDaemon(l *lease, w *selfWatch, naming string) {
+	var (
+		retry  = backoff.New(backoff.Config{Jitter: DefaultKeepAliveJitter})
+		prefix = keyPrefixes(naming)[0]
+	)
+	for attempt := 0; ; attempt++ {
+		// changes are missed while the watch is down, e.g. the registry is restored from a snapshot
+		r.reconcileLease(l, w, naming)
+
+		watcher := client.NewWatcher(
+			hack.String2Bytes(prefix),
+			client.WatchWithPrefix(),
+			client.WatchWithNamespace(namespace.Load()),
+		)
+		notify, _ := watcher.Notify()
+		go func() {
+			_ = r.kvClient.WatchPrefix(w.ctx, watcher)
+			// the watcher isn't closed if the watch can't be opened
+			_ = watcher.Close()
+		}()
+
+		for value := range notify {
+			attempt = 0
+			r.checkSelfKey(l, w, hack.Bytes2String(value.Key), value.Value)
+		}
+
+		timer := time.NewTimer(retry.Delay(attempt))
+		select {
+		case <-w.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// reconcileLease compares the endpoints of the lease under naming with the registry.
+func (r *discoveryAndRegister) reconcileLease(l *lease, w *selfWatch, naming string) {
+	for _, endpoint := range l.snapshot() {
+		if endpoint.Naming != naming {
+			continue
+		}
+		value, err := r.kvClient.Get(w.ctx, hack.String2Bytes(EndpointKey(naming, endpoint.ID)), namespace.Load())
+		switch {
+		case status.Code(err) == codes.NotFound:
+			r.checkSelfKey(l, w, EndpointKey(naming, endpoint.ID), nil)
+		case err == nil:
+			r.checkSelfKey(l, w, EndpointKey(naming, endpoint.ID), value.Data)
+		}
+	}
+}
+
+// checkSelfKey applies the policy if the value of the key isn't the endpoint of the lease, a nil value is deleted.
+// A key missing once the deadline of the lease passed is expired rather than deleted, it is reported as LeaseLost
+// and written back by KeepAlive, the policy isn't applied.
+func (r *discoveryAndRegister) checkSelfKey(l *lease, w *selfWatch, key string, value []byte) {
+	if w.ctx.Err() != nil {
+		return
+	}
+
+	naming, id, err := ParseEndpointPath(key)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	endpoint, ok := l.endpoints[EndpointKey(naming, id)]
+	l.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	expected, _ := endpoint.Marshal()
+	event := LeaseEvent{
+		Type:      LeaseEndpointModified,
+		Endpoint:  endpoint,
+		Remaining: time.Until(time.UnixMilli(l.deadline.Load())),
+	}
+	switch {
+	case value == nil && event.Remaining <= 0:
+		return
+	case value == nil:
+		event.Type = LeaseEndpointDeleted
+	case bytes.Equal(value, expected):
+		return
+	case endpoint.Owner != "":
+		// claimed by another process, it is no longer refreshed by the lease
+		if registered, perr := ParseEndpoint(value); perr != nil || registered.Owner != endpoint.Owner {
+			l.detach(naming, id)
+			event.Err = &EndpointConflictError{Naming: naming, ID: id, Conflict: registered}
+			w.emit(event)
+			return
+		}
+	}
+	w.emit(event)
+
+	switch {
+	case w.policy == SelfWatchAlert:
+		return
+	case w.policy == SelfWatchRespectDeletion && event.Type == LeaseEndpointDeleted:
+		l.detach(naming, id)
+		// the other keys of the layout
+		for _, k := range endpointKeys(naming, id)[1:] {
+			_ = r.kvClient.Delete(r.ctx, hack.String2Bytes(k), namespace.Load())
+		}
+		if srv, ok := r.services.Load(naming); ok {
+			srv.DelEndpoints(id)
+		}
+		return
+	}
+
+	// a revoked lease isn't written back
+	l.alive.RLock()
+	if l.revoked {
+		l.alive.RUnlock()
+		return
+	}
+	err = r.setEndpoint(naming, id, expected, l.ttl)
+	l.alive.RUnlock()
+	if err != nil {
+		// written back by the next KeepAlive
+		return
+	}
+	event.Type = LeaseEndpointRestored
+	w.emit(event)
+}
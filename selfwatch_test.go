@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// watchTestLease watches the lease of testLease with the policy, the events are sent to the returned channel.
+func watchTestLease(t *testing.T, policy SelfWatchPolicy) (*discoveryAndRegister, *fakeKV, LeaseID, <-chan LeaseEvent) {
+	t.Helper()
+	c, kv, id := testLease(t, 5)
+	r := c.DiscoveryAndRegister.(*discoveryAndRegister)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	events := make(chan LeaseEvent, 8)
+	if err := r.WatchLease(ctx, id, policy, func(event LeaseEvent) {
+		events <- event
+	}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return kv.watching(1) }, "the lease isn't watched")
+	return r, kv, id, events
+}
+
+func TestSelfWatch_RespectDeletion(t *testing.T) {
+	r, kv, id, events := watchTestLease(t, SelfWatchRespectDeletion)
+	l, _ := r.leases.Load(id)
+
+	key := EndpointKey(testNaming, testEndpointID(0))
+	if err := kv.Delete(context.Background(), []byte(key), nil); err != nil {
+		t.Fatal(err)
+	}
+	event := nextLeaseEvent(t, events, time.Second)
+	if event.Type != LeaseEndpointDeleted {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	eventually(t, func() bool { return len(l.snapshot()) == 0 }, "the deleted endpoint isn't detached")
+	if _, ok := r.loadService(testNaming).Endpoint(testEndpointID(0)); ok {
+		t.Fatal("the deleted endpoint isn't removed")
+	}
+}
+
+func TestSelfWatch_ExpiredLease(t *testing.T) {
+	r, kv, id, events := watchTestLease(t, SelfWatchRespectDeletion)
+	l, _ := r.leases.Load(id)
+
+	// the keepalive is missed, the key expires after the deadline of the lease
+	l.deadline.Store(time.Now().Add(-time.Second).UnixMilli())
+	key := EndpointKey(testNaming, testEndpointID(0))
+	kv.expire(key)
+
+	// including the NotFound of the reconciliation after a partition
+	r.reconcileLease(l, l.watch, testNaming)
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if len(l.snapshot()) != 1 {
+		t.Fatal("the expired endpoint is detached from the lease")
+	}
+
+	// written back once the lease is refreshed
+	if err := r.KeepAlive(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.value(key); !ok {
+		t.Fatal("the expired endpoint isn't written back")
+	}
+}
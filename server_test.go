@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testNaming = "sdr.test"
+	testMethod = "/sdr.test.Test/Call"
+)
+
+type testHandler func(stream grpc.ServerStream) error
+
+// testServer answers every method through its handler.
+type testServer struct {
+	addr    string
+	calls   atomic.Int64
+	handler atomic.Pointer[testHandler]
+}
+
+func (s *testServer) handle(handler testHandler) {
+	s.handler.Store(&handler)
+}
+
+func newTestServer(t *testing.T, handler testHandler) *testServer {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{addr: lis.Addr().String()}
+	ts.handle(handler)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		ts.calls.Add(1)
+		return (*ts.handler.Load())(stream)
+	}))
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	return ts
+}
+
+// reply answers a unary call, or the first message of a stream.
+func reply(stream grpc.ServerStream) error {
+	if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+		return err
+	}
+	return stream.SendMsg(&emptypb.Empty{})
+}
+
+func fail(code codes.Code) testHandler {
+	return func(grpc.ServerStream) error {
+		return status.Error(code, code.String())
+	}
+}
+
+// delay replies after d, or fails once the call is canceled.
+func delay(d time.Duration) testHandler {
+	return func(stream grpc.ServerStream) error {
+		select {
+		case <-time.After(d):
+			return reply(stream)
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func testEndpointID(i int) string {
+	return fmt.Sprintf("node-%d", i)
+}
+
+// newTestService returns a service of the servers, the endpoint of servers[i] is testEndpointID(i).
+func newTestService(t *testing.T, servers []*testServer, opts ...ServiceOption) *service {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	srv := NewService(ctx, testNaming, grpc.WithTransportCredentials(insecure.NewCredentials())).(*service)
+	srv.Configure(opts...)
+	t.Cleanup(srv.CloseAliveConn)
+
+	endpoints := make([]*Endpoint, 0, len(servers))
+	for i, ts := range servers {
+		endpoints = append(endpoints, NewEndpoint(testEndpointID(i), ts.addr, 60, nil))
+	}
+	srv.AddEndpoints(endpoints...)
+
+	readyCtx, readyCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer readyCancel()
+	if err := srv.WaitReady(readyCtx, len(servers)); err != nil {
+		t.Fatalf("endpoints not ready: %v", err)
+	}
+	return srv
+}
+
+func invokeTest(srv *service, opts ...InvokeOption) error {
+	return srv.Invoke(context.Background(), testMethod, &emptypb.Empty{}, &emptypb.Empty{}, opts...)
+}
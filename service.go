@@ -2,14 +2,26 @@ package discovery
 
 import (
 	"context"
-	"github.com/RealFax/RedQueen/client"
 	"github.com/RealFax/red-discovery/internal/balancer"
+	"github.com/RealFax/red-discovery/internal/breaker"
+	"github.com/RealFax/red-discovery/internal/latency"
+	"github.com/RealFax/red-discovery/internal/limiter"
 	"github.com/RealFax/red-discovery/internal/maputil"
+	"github.com/RealFax/red-discovery/internal/notify"
+	"github.com/RealFax/red-discovery/internal/pool"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+const (
+	latencyWindowSize = 256
+)
+
+type ServiceOption func(*service)
+
 type Service interface {
 	// Naming returns service naming.
 	Naming() string
@@ -19,27 +31,59 @@ type Service interface {
 
 	WithEndpointNaming(endpointID string) string
 
-	// Alive returns whether the current service is available.
+	// Alive returns whether the current service has any ready endpoint.
 	Alive() bool
 
+	// ReadyCount returns the number of endpoints which are dialed, healthy, unexpired and not draining.
+	ReadyCount() int
+
+	// WaitReady blocks until at least min endpoints are ready or ctx is done.
+	WaitReady(ctx context.Context, min int) error
+
 	// AddEndpoints by endpoints slice.
 	AddEndpoints(endpoints ...*Endpoint)
 
 	// DelEndpoints by id slice.
 	DelEndpoints(ids ...string)
 
+	// RangeEndpoints ranges over the unexpired endpoints, the lifecycle of an endpoint is reported by Endpoint.State.
 	RangeEndpoints(f func(endpoint *Endpoint) bool)
 
+	// Connecting reports whether the endpoint is being dialed, it can't be picked until dialed.
+	Connecting(endpointID string) bool
+
+	// Endpoint returns the endpoint of id, including an expired one, see Endpoint.State.
+	Endpoint(endpointID string) (*Endpoint, bool)
+
 	// AliveConn returns the grpc connection of all service endpoints on internal.
 	//
 	// DON'T CLOSE GRPC CONN
 	AliveConn() map[string]*grpc.ClientConn
 
 	// NextAliveConn returns the internal grpc connection through the load balancing algorithm.
+	// The connection of a half-open endpoint is returned for a single probing call,
+	// a probe which isn't used is given back after the OpenTimeout of the circuit breaker.
 	//
 	// DON"T CLOSE GRPC CONN
 	NextAliveConn() (*grpc.ClientConn, error)
 
+	// Invoke performs a unary RPC on an endpoint selected by the load balancing algorithm,
+	// retryable failures are retried on endpoints which have not been tried by this call.
+	Invoke(ctx context.Context, method string, req, resp any, opts ...InvokeOption) error
+
+	// NewStream creates a stream on an endpoint selected by the load balancing algorithm,
+	// only the creation of the stream is retried.
+	NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...InvokeOption) (grpc.ClientStream, error)
+
+	// Stats returns the results of the calls made on the connections of an endpoint.
+	Stats(endpointID string) (EndpointStats, bool)
+
+	// CircuitState returns the circuit breaker state of an endpoint.
+	CircuitState(endpointID string) (CircuitState, bool)
+
+	// Configure apply options to the service.
+	Configure(opts ...ServiceOption)
+
 	// CloseAliveConn Close internal all grpc conn.
 	CloseAliveConn()
 
@@ -52,32 +96,255 @@ type Service interface {
 
 // simple Service implement
 type service struct {
-	ctx            context.Context
-	dialOpts       []grpc.DialOption
-	aliveConnCount atomic.Int64
-	naming         *atomic.Pointer[string]
-	endpoints      *maputil.Map[string, *Endpoint] // map<id, *Endpoint>
-	aliveConn      *maputil.Map[string, *client.ConnectionManager /**grpc.ClientConn*/]
-	loadBalance    balancer.LoadBalance[string, *Endpoint]
+	ctx        context.Context
+	dialOpts   []grpc.DialOption
+	naming     *atomic.Pointer[string]
+	endpoints  *maputil.Map[string, *Endpoint] // map<id, *Endpoint>
+	aliveConn  *maputil.Map[string, *pool.Pool]
+	stats      *maputil.Map[string, *endpointStats]
+	breakerCfg atomic.Pointer[CircuitBreakerConfig]
+	breakers   *maputil.Map[string, *breaker.Breaker]
+
+	limiter          atomic.Pointer[trafficLimiter]
+	endpointLimits   atomic.Pointer[Limits]
+	publishedLimits  atomic.Bool
+	endpointLimiters *maputil.Map[string, *trafficLimiter]
+	adaptiveCfg      atomic.Pointer[AdaptiveLimitConfig]
+	adaptive         *maputil.Map[string, *limiter.AIMD]
+
+	poolSize        atomic.Int64
+	lazyDial        atomic.Bool
+	connBudget      atomic.Pointer[pool.Budget]
+	idleTimeout     atomic.Int64
+	janitor         sync.Once
+	dialConcurrency atomic.Int64
+	dialMu          sync.Mutex
+	dialQueue       []*Endpoint
+	dialWorkers     int
+	dialTimeout     atomic.Int64
+	subsetSize      atomic.Int64
+	subsetClientID  atomic.Pointer[string]
+	subsetMu        sync.Mutex
+	subset          atomic.Pointer[map[string]struct{}]
+
+	latency       *latency.Window
+	hedge         hedgeBudget
+	ready         notify.Notifier
+	eventHandlers atomic.Pointer[[]EventHandler]
+	loadBalance   balancer.LoadBalance[string, *Endpoint]
 }
 
+// dialEndpoints queues the endpoints to be dialed in background, at most the dial concurrency of endpoints are dialed at once.
+// The endpoints are connecting until dialed, then EventEndpointReady or EventEndpointDialFailed is emitted.
 func (s *service) dialEndpoints(endpoints []*Endpoint) {
-	wg := sync.WaitGroup{}
-	for _, endpoint := range endpoints {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			pool, err := client.NewConnectionManager(s.ctx, endpoint.PeerAddress, 16, s.dialOpts...)
-			if err != nil {
-				s.endpoints.Delete(endpoint.ID)
-				return
+	s.dialMu.Lock()
+	defer s.dialMu.Unlock()
+	s.dialQueue = append(s.dialQueue, endpoints...)
+	for s.dialWorkers < int(s.dialConcurrency.Load()) && s.dialWorkers < len(s.dialQueue) {
+		s.dialWorkers++
+		go s.dialWorker()
+	}
+}
+
+// dialWorker dials the queued endpoints one by one,
+// it exits once the queue is empty or the dial concurrency is lowered.
+func (s *service) dialWorker() {
+	for {
+		s.dialMu.Lock()
+		if s.ctx.Err() != nil {
+			s.dialQueue = nil
+		}
+		if len(s.dialQueue) == 0 || s.dialWorkers > int(s.dialConcurrency.Load()) {
+			s.dialWorkers--
+			s.dialMu.Unlock()
+			return
+		}
+		endpoint := s.dialQueue[0]
+		s.dialQueue[0] = nil
+		s.dialQueue = s.dialQueue[1:]
+		s.dialMu.Unlock()
+
+		s.dialEndpoint(endpoint)
+	}
+}
+
+func (s *service) dialEndpoint(endpoint *Endpoint) {
+	// the endpoint was deleted or replaced while queued
+	if e, ok := s.endpoints.Load(endpoint.ID); !ok || e != endpoint {
+		return
+	}
+	p, err := s.newPool(endpoint)
+	if err == nil {
+		err = s.waitPoolReady(p)
+	}
+
+	// the endpoint was deleted or replaced while dialing
+	if e, ok := s.endpoints.Load(endpoint.ID); !ok || e != endpoint {
+		if err == nil {
+			_ = p.Close()
+		}
+		return
+	}
+
+	if err != nil {
+		s.DelEndpoints(endpoint.ID)
+		s.emit(Event{Type: EventEndpointDialFailed, Endpoint: endpoint, Err: err})
+		return
+	}
+
+	// the connections to the previous address of an updated endpoint, the calls in flight are finished
+	if prev, ok := s.aliveConn.Swap(endpoint.ID, p); ok && prev != p {
+		_ = prev.Drain()
+	}
+
+	// deleted between the check and the store
+	if e, ok := s.endpoints.Load(endpoint.ID); !ok || e != endpoint {
+		s.aliveConn.CompareAndDelete(endpoint.ID, p)
+		_ = p.Close()
+		return
+	}
+
+	if s.inSubset(endpoint.ID) {
+		s.transition(endpoint, endpoint.activeState(), EndpointConnecting)
+	} else {
+		s.transition(endpoint, EndpointIdle, EndpointConnecting)
+	}
+	// emitted once the endpoint can be picked
+	s.emit(Event{Type: EventEndpointReady, Endpoint: endpoint})
+}
+
+// pick selects an alive endpoint which is not in exclude through the load balancing algorithm,
+// only ready endpoints and unhealthy endpoints probing their recovery are picked,
+// endpoints out of the subset, with an open circuit, exceeded limits or a saturated adaptive limit are skipped.
+//
+// A half-open circuit reserves a probe for the call, the result of the call is reported
+// by the interceptors of the endpoint connections.
+// If reserve is true, the endpoint limiter reserves a concurrency slot for the call,
+// the caller must release the returned limiter once the call is finished,
+// the limiter of the endpoint can be replaced by then.
+func (s *service) pick(exclude map[string]struct{}, reserve bool) (*Endpoint, *grpc.ClientConn, *trafficLimiter, error) {
+	// endpoints which can't get a connection from the connection budget
+	var exhausted map[string]struct{}
+	for {
+		var (
+			limited bool
+			p       *pool.Pool
+			probe   *breaker.Breaker
+			l       *trafficLimiter
+		)
+		endpoint, err := s.loadBalance.Pick(func(endpoint *Endpoint) bool {
+			if _, ok := exclude[endpoint.ID]; ok || !s.inSubset(endpoint.ID) {
+				return false
 			}
-			s.aliveConn.Store(endpoint.ID, pool)
-			s.aliveConnCount.Add(1)
-		}()
+			if state := endpoint.State(); state != EndpointReady && state != EndpointUnhealthy {
+				return false
+			}
+			if _, ok := exhausted[endpoint.ID]; ok {
+				return false
+			}
+			var ok bool
+			if p, ok = s.aliveConn.Load(endpoint.ID); !ok {
+				return false
+			}
+			b, _ := s.breakers.Load(endpoint.ID)
+			if b != nil && !b.Ready() {
+				return false
+			}
+			if a, _ := s.adaptive.Load(endpoint.ID); a != nil && !a.Available() {
+				limited = true
+				return false
+			}
+
+			// the circuit is checked first, a probe can be given back but a rate token can't
+			if b != nil && !b.Allow() {
+				return false
+			}
+			l, _ = s.endpointLimiters.Load(endpoint.ID)
+			if (reserve && !l.tryAcquire()) || (!reserve && !l.allow()) {
+				if b != nil {
+					b.Cancel()
+				}
+				limited = true
+				return false
+			}
+			probe = b
+			return true
+		})
+		switch {
+		case err == nil:
+		case limited:
+			return nil, nil, nil, ErrLimitExceeded
+		default:
+			return nil, nil, nil, ErrServiceUnreachable
+		}
 
+		conn, err := p.Get()
+		if err == nil {
+			return endpoint, conn, l, nil
+		}
+		if reserve {
+			l.release()
+		}
+		if probe != nil {
+			probe.Cancel()
+		}
+		if !errors.Is(err, pool.ErrPoolExhausted) {
+			return nil, nil, nil, err
+		}
+		if exhausted == nil {
+			exhausted = make(map[string]struct{})
+		}
+		exhausted[endpoint.ID] = struct{}{}
+	}
+}
+
+// abandon gives back the half-open probe reserved for a call which result isn't reported.
+func (s *service) abandon(endpointID string) {
+	if b, ok := s.breakers.Load(endpointID); ok {
+		b.Cancel()
+	}
+}
+
+func (s *service) newEndpointLimiter(endpoint *Endpoint) *trafficLimiter {
+	if s.publishedLimits.Load() {
+		if l, ok := endpoint.Limits(); ok {
+			return newTrafficLimiter(l)
+		}
+	}
+	return newTrafficLimiter(*s.endpointLimits.Load())
+}
+
+func (s *service) resetEndpointLimiters() {
+	s.endpoints.Range(func(id string, endpoint *Endpoint) bool {
+		s.endpointLimiters.Store(id, s.newEndpointLimiter(endpoint))
+		return true
+	})
+}
+
+// report records the result and round trip time of a call made on endpoint.
+func (s *service) report(endpointID string, rtt time.Duration, err error) {
+	if err == nil {
+		s.latency.Observe(rtt)
+	}
+	if stats, ok := s.stats.Load(endpointID); ok {
+		stats.observe(err)
+	}
+	b, ok := s.breakers.Load(endpointID)
+	if !ok {
+		return
+	}
+	b.Done(isEndpointFailure(err))
+
+	endpoint, ok := s.endpoints.Load(endpointID)
+	if !ok {
+		return
+	}
+	switch b.State() {
+	case CircuitOpen:
+		s.transition(endpoint, EndpointUnhealthy, EndpointReady)
+	case CircuitClosed:
+		s.transition(endpoint, EndpointReady, EndpointUnhealthy)
 	}
-	wg.Wait()
 }
 
 func (s *service) Naming() string {
@@ -93,17 +360,46 @@ func (s *service) WithEndpointNaming(endpointID string) string {
 	if !ok {
 		return s.Naming()
 	}
+	// the Service of a qualified Discovery, e.g. "orders@v2", is named after the selector
+	if endpoint.Naming != "" {
+		return endpoint.WithNaming(endpoint.Naming)
+	}
 	return endpoint.WithNaming(s.Naming())
 }
 
 func (s *service) Alive() bool {
+	return s.ReadyCount() != 0
+}
+
+func (s *service) ReadyCount() int {
 	count := 0
-	s.aliveConn.Range(func(_ string, _ *client.ConnectionManager) bool {
-		count++
+	s.endpoints.Range(func(id string, endpoint *Endpoint) bool {
+		if endpoint.State() == EndpointReady && s.aliveConn.Exist(id) {
+			count++
+		}
 		return true
 	})
+	return count
+}
+
+// readyConn returns a dialed connection of a ready endpoint, no rate token, probe or connection is taken.
+func (s *service) readyConn() (conn *grpc.ClientConn) {
+	s.endpoints.Range(func(id string, endpoint *Endpoint) bool {
+		if endpoint.State() != EndpointReady {
+			return true
+		}
+		if p, ok := s.aliveConn.Load(id); ok {
+			conn, _ = p.Peek()
+		}
+		return conn == nil
+	})
+	return
+}
 
-	return s.aliveConnCount.Load() != 0
+func (s *service) WaitReady(ctx context.Context, min int) error {
+	return s.ready.WaitUntil(ctx, func() bool {
+		return s.ReadyCount() >= min
+	})
 }
 
 func (s *service) AddEndpoints(endpoints ...*Endpoint) {
@@ -111,29 +407,101 @@ func (s *service) AddEndpoints(endpoints ...*Endpoint) {
 
 	for _, endpoint := range endpoints {
 		if e, ok := s.endpoints.Load(endpoint.ID); ok {
-			e.lastUpdated = endpoint.lastUpdated
+			if !e.same(endpoint) {
+				if s.updateEndpoint(e, endpoint) {
+					waitDialEndpoints = append(waitDialEndpoints, endpoint)
+				}
+				continue
+			}
+			e.touch(atomic.LoadInt64(&endpoint.lastUpdated))
 			e.SetTTL(endpoint.TTL())
+			e.setDraining(endpoint.Draining)
+			if endpoint.Draining {
+				s.transition(e, EndpointDraining, EndpointReady, EndpointUnhealthy)
+			} else {
+				s.transition(e, EndpointReady, EndpointDraining)
+			}
 			continue
 		}
+		endpoint.setDraining(endpoint.Draining)
+		atomic.StoreUint32(&endpoint.state, uint32(EndpointConnecting))
+		s.stats.Store(endpoint.ID, &endpointStats{})
+		s.breakers.Store(endpoint.ID, s.newBreaker())
+		s.endpointLimiters.Store(endpoint.ID, s.newEndpointLimiter(endpoint))
+		s.adaptive.Store(endpoint.ID, s.newAdaptiveLimiter())
 		s.endpoints.Store(endpoint.ID, endpoint)
 		s.loadBalance.Append(endpoint)
 		waitDialEndpoints = append(waitDialEndpoints, endpoint)
 	}
 
+	if len(waitDialEndpoints) != 0 {
+		s.resetSubset()
+	}
+	// refreshed TTL may revive expired endpoints
+	s.ready.Notify()
 	s.dialEndpoints(waitDialEndpoints)
 }
 
+// updateEndpoint replaces prev by the endpoint of the same id carrying changed metadata, address or identity,
+// it reports whether the endpoint must be redialed.
+// The state and connections of prev are kept unless the address is changed,
+// the connections to the previous address are drained once the new address is dialed, see pool.Drain.
+func (s *service) updateEndpoint(prev, endpoint *Endpoint) bool {
+	state := EndpointState(atomic.LoadUint32(&prev.state))
+	// a dial in flight is discarded once prev is replaced
+	redial := state == EndpointConnecting || prev.PeerAddress != endpoint.PeerAddress
+
+	endpoint.setDraining(endpoint.Draining)
+	if redial {
+		state = EndpointConnecting
+	}
+	atomic.StoreUint32(&endpoint.state, uint32(state))
+
+	if prev.PeerAddress != endpoint.PeerAddress {
+		// the health of the previous address says nothing about the new one
+		s.breakers.Store(endpoint.ID, s.newBreaker())
+		s.adaptive.Store(endpoint.ID, s.newAdaptiveLimiter())
+	}
+	if s.publishedLimits.Load() {
+		s.endpointLimiters.Store(endpoint.ID, s.newEndpointLimiter(endpoint))
+	}
+	s.endpoints.Store(endpoint.ID, endpoint)
+	s.loadBalance.Replace(endpoint)
+	s.emit(Event{Type: EventEndpointUpdated, Endpoint: endpoint})
+
+	if !redial {
+		if endpoint.Draining {
+			s.transition(endpoint, EndpointDraining, EndpointReady, EndpointUnhealthy)
+		} else {
+			s.transition(endpoint, EndpointReady, EndpointDraining)
+		}
+	}
+	return redial
+}
+
 func (s *service) DelEndpoints(ids ...string) {
 	for _, id := range ids {
 		s.endpoints.Delete(id)
+		s.stats.Delete(id)
+		s.breakers.Delete(id)
+		s.endpointLimiters.Delete(id)
+		s.adaptive.Delete(id)
 		s.loadBalance.Remove(id)
-		pool, ok := s.aliveConn.Load(id)
-		if !ok {
-			continue
+		if p, ok := s.aliveConn.LoadAndDelete(id); ok {
+			_ = p.Close()
 		}
-		s.aliveConnCount.Add(-1)
-		_ = pool.Close()
 	}
+	s.resetSubset()
+	s.ready.Notify()
+}
+
+func (s *service) Connecting(endpointID string) bool {
+	endpoint, ok := s.endpoints.Load(endpointID)
+	return ok && endpoint.State() == EndpointConnecting
+}
+
+func (s *service) Endpoint(endpointID string) (*Endpoint, bool) {
+	return s.endpoints.Load(endpointID)
 }
 
 func (s *service) RangeEndpoints(f func(endpoint *Endpoint) bool) {
@@ -150,8 +518,11 @@ func (s *service) AliveConn() map[string]*grpc.ClientConn {
 		err error
 		m   = make(map[string]*grpc.ClientConn)
 	)
-	s.aliveConn.Range(func(key string, manager *client.ConnectionManager) bool {
-		if m[key], err = manager.Alloc(); err != nil {
+	s.aliveConn.Range(func(key string, p *pool.Pool) bool {
+		if !s.inSubset(key) {
+			return true
+		}
+		if m[key], err = p.Get(); err != nil {
 			return false
 		}
 		return true
@@ -160,24 +531,43 @@ func (s *service) AliveConn() map[string]*grpc.ClientConn {
 }
 
 func (s *service) NextAliveConn() (*grpc.ClientConn, error) {
-	endpoint, err := s.loadBalance.Next()
-	if err != nil {
-		return nil, err
+	if !s.limiter.Load().allow() {
+		return nil, ErrLimitExceeded
+	}
+	_, conn, _, err := s.pick(nil, false)
+	return conn, err
+}
+
+func (s *service) Stats(endpointID string) (EndpointStats, bool) {
+	stats, ok := s.stats.Load(endpointID)
+	if !ok {
+		return EndpointStats{}, false
 	}
-	pool, ok := s.aliveConn.Load(endpoint.ID)
+	return stats.snapshot(), true
+}
+
+func (s *service) CircuitState(endpointID string) (CircuitState, bool) {
+	b, ok := s.breakers.Load(endpointID)
 	if !ok {
-		return nil, ErrServiceUnreachable
+		return CircuitClosed, false
+	}
+	return b.State(), true
+}
+
+func (s *service) Configure(opts ...ServiceOption) {
+	for _, opt := range opts {
+		opt(s)
 	}
-	return pool.Alloc()
 }
 
 func (s *service) CloseAliveConn() {
-	s.aliveConn.Range(func(key string, manager *client.ConnectionManager) bool {
-		s.aliveConn.Delete(key)
-		s.aliveConnCount.Add(-1)
-		_ = manager.Close()
+	s.aliveConn.Range(func(key string, _ *pool.Pool) bool {
+		if p, ok := s.aliveConn.LoadAndDelete(key); ok {
+			_ = p.Close()
+		}
 		return true
 	})
+	s.ready.Notify()
 }
 
 func (s *service) DialContext(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
@@ -237,12 +627,25 @@ func NewService(ctx context.Context, naming string, dialOpts ...grpc.DialOption)
 	_naming := atomic.Pointer[string]{}
 	_naming.Store(&naming)
 
-	return &service{
-		ctx:         ctx,
-		dialOpts:    dialOpts,
-		naming:      &_naming,
-		endpoints:   maputil.New[string, *Endpoint](),
-		aliveConn:   maputil.New[string, *client.ConnectionManager](),
-		loadBalance: balancer.NewRoundRobin[string, *Endpoint](),
+	srv := &service{
+		ctx:       ctx,
+		dialOpts:  dialOpts,
+		naming:    &_naming,
+		endpoints: maputil.New[string, *Endpoint](),
+		aliveConn: maputil.New[string, *pool.Pool](),
+		stats:     maputil.New[string, *endpointStats](),
+		breakers:  maputil.New[string, *breaker.Breaker](),
+
+		endpointLimiters: maputil.New[string, *trafficLimiter](),
+		adaptive:         maputil.New[string, *limiter.AIMD](),
+		latency:          latency.NewWindow(latencyWindowSize),
+		loadBalance:      balancer.NewRoundRobin[string, *Endpoint](),
 	}
+	breakerCfg := DefaultCircuitBreakerConfig
+	srv.breakerCfg.Store(&breakerCfg)
+	srv.endpointLimits.Store(&Limits{})
+	srv.dialConcurrency.Store(DefaultDialConcurrency)
+	srv.poolSize.Store(DefaultPoolSize)
+	srv.dialTimeout.Store(int64(DefaultDialTimeout))
+	return srv
 }
@@ -0,0 +1,155 @@
+package discovery
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestService_WaitReady(t *testing.T) {
+	ts := newTestServer(t, reply)
+	srv := newTestService(t, nil)
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- srv.WaitReady(ctx, 1)
+	}()
+	srv.AddEndpoints(NewEndpoint(testEndpointID(0), ts.addr, 60, nil))
+	if err := <-done; err != nil {
+		t.Fatalf("the waiter wasn't woken by the ready endpoint: %v", err)
+	}
+
+	srv.CloseAliveConn()
+	if count := srv.ReadyCount(); count != 0 {
+		t.Fatalf("endpoints without connections counted ready: %d", count)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.WaitReady(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestService_ReadyCountConcurrent(t *testing.T) {
+	var (
+		servers     = []*testServer{newTestServer(t, reply), newTestServer(t, reply)}
+		srv         = newTestService(t, servers)
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		wg          sync.WaitGroup
+	)
+	defer cancel()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ctx.Err() == nil; j++ {
+				id := testEndpointID(j % len(servers))
+				switch (i + j) % 3 {
+				case 0:
+					srv.AddEndpoints(NewEndpoint(id, servers[j%len(servers)].addr, 60, nil))
+				case 1:
+					srv.DelEndpoints(id)
+				default:
+					srv.CloseAliveConn()
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if count := srv.ReadyCount(); count < 0 || count > len(servers) {
+					t.Errorf("unexpected ready count: %d", count)
+					return
+				}
+				waitCtx, waitCancel := context.WithTimeout(ctx, time.Millisecond)
+				_ = srv.WaitReady(waitCtx, 1)
+				waitCancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the service converges once the churn stops
+	srv.DelEndpoints(testEndpointID(0), testEndpointID(1))
+	for i, ts := range servers {
+		srv.AddEndpoints(NewEndpoint(testEndpointID(i), ts.addr, 60, nil))
+	}
+	readyCtx, readyCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer readyCancel()
+	if err := srv.WaitReady(readyCtx, len(servers)); err != nil {
+		t.Fatalf("endpoints not ready after the churn: %v", err)
+	}
+}
+
+func TestService_UpdateEndpointAddress(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		prev    = newTestServer(t, func(stream grpc.ServerStream) error {
+			<-release
+			return reply(stream)
+		})
+		next = newTestServer(t, reply)
+		srv  = newTestService(t, []*testServer{prev})
+	)
+
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	stream, err := srv.NewStream(context.Background(), desc, testMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.SendMsg(&emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	_ = stream.CloseSend()
+	eventually(t, func() bool { return prev.calls.Load() == 1 }, "the stream didn't reach the previous address")
+
+	endpoint := NewEndpoint(testEndpointID(0), next.addr, 60, nil)
+	srv.AddEndpoints(endpoint)
+	eventually(t, func() bool {
+		p, ok := srv.aliveConn.Load(testEndpointID(0))
+		return ok && p.Target() == next.addr && endpoint.State() == EndpointReady
+	}, "the new address isn't dialed")
+
+	if err = invokeTest(srv, WithInvokeTimeout(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if next.calls.Load() != 1 {
+		t.Fatalf("the call wasn't sent to the new address, calls: %d", next.calls.Load())
+	}
+
+	// the stream to the previous address is finished on the drained connection
+	close(release)
+	if err = stream.RecvMsg(&emptypb.Empty{}); err != nil {
+		t.Fatalf("the stream to the previous address was interrupted: %v", err)
+	}
+}
+
+func TestService_ReadyConnKeepsRateTokens(t *testing.T) {
+	srv := newTestService(
+		t,
+		[]*testServer{newTestServer(t, reply)},
+		WithLimits(Limits{Rate: 1, Burst: 1}),
+		WithEndpointLimits(Limits{Rate: 1, Burst: 1}),
+	)
+
+	for i := 0; i < 3; i++ {
+		if conn := srv.readyConn(); conn == nil {
+			t.Fatal("the connection of the ready endpoint isn't found")
+		}
+	}
+	if _, err := srv.NextAliveConn(); err != nil {
+		t.Fatalf("the lookup spent the rate token: %v", err)
+	}
+
+	srv.CloseAliveConn()
+	if conn := srv.readyConn(); conn != nil {
+		t.Fatal("a connection is found without dialed connections")
+	}
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"github.com/RealFax/red-discovery/internal/subset"
+	"github.com/google/uuid"
+	"sync/atomic"
+)
+
+var (
+	// clientID identifies this process when selecting the subset of endpoints.
+	clientID atomic.Pointer[string]
+)
+
+func init() {
+	id := uuid.NewString()
+	clientID.Store(&id)
+}
+
+func ClientID() string {
+	return *clientID.Load()
+}
+
+// SetClientID set the identity of this process used by the subsetting, default is a random UUID.
+//
+// A stable identity, e.g. the hostname, keeps the subset of the client across restarts.
+// It should be set before the services are discovered.
+func SetClientID(id string) {
+	if id != "" {
+		clientID.Store(&id)
+	}
+}
+
+// WithSubset only connects to a deterministic subset of size endpoints,
+// the subset of this client changes minimally when endpoints join or leave.
+// Endpoints in the subset are dialed lazily.
+func WithSubset(size int) ServiceOption {
+	return func(s *service) {
+		s.subsetSize.Store(int64(size))
+		s.resetSubset()
+	}
+}
+
+// WithSubsetClientID overrides the identity set by SetClientID for the service.
+func WithSubsetClientID(id string) ServiceOption {
+	return func(s *service) {
+		s.subsetClientID.Store(&id)
+		s.resetSubset()
+	}
+}
+
+func (s *service) clientID() string {
+	if id := s.subsetClientID.Load(); id != nil && *id != "" {
+		return *id
+	}
+	return ClientID()
+}
+
+// inSubset reports whether the endpoint is in the subset of this client.
+func (s *service) inSubset(endpointID string) bool {
+	members := s.subset.Load()
+	if members == nil {
+		return true
+	}
+	_, ok := (*members)[endpointID]
+	return ok
+}
+
+// resetSubset selects the subset again after the endpoints changed,
+// endpoints leaving the subset become idle and their connections are closed.
+// It is called once per batch of endpoints, nothing is done while the subsetting is disabled.
+func (s *service) resetSubset() {
+	s.subsetMu.Lock()
+	defer s.subsetMu.Unlock()
+
+	size := int(s.subsetSize.Load())
+	if size <= 0 && s.subset.Load() == nil {
+		return
+	}
+	if size <= 0 {
+		s.subset.Store(nil)
+	} else {
+		var ids []string
+		s.endpoints.Range(func(id string, _ *Endpoint) bool {
+			ids = append(ids, id)
+			return true
+		})
+
+		members := make(map[string]struct{}, size)
+		for _, id := range subset.Rendezvous(s.clientID(), ids, size) {
+			members[id] = struct{}{}
+		}
+		s.subset.Store(&members)
+	}
+
+	s.endpoints.Range(func(id string, endpoint *Endpoint) bool {
+		if s.inSubset(id) {
+			if s.aliveConn.Exist(id) {
+				s.transition(endpoint, endpoint.activeState(), EndpointIdle)
+			}
+			return true
+		}
+		s.transition(endpoint, EndpointIdle, EndpointReady, EndpointDraining, EndpointUnhealthy)
+		if p, ok := s.aliveConn.Load(id); ok {
+			p.Evict()
+		}
+		return true
+	})
+}
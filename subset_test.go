@@ -0,0 +1,29 @@
+package discovery
+
+import (
+	"testing"
+)
+
+func TestService_ConfigureSubsetConcurrently(t *testing.T) {
+	srv := newTestService(t, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			srv.Configure(WithSubset(i%4+1), WithSubsetClientID(testEndpointID(i)))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		srv.AddEndpoints(NewEndpoint(testEndpointID(i), "127.0.0.1:1", 60, nil))
+	}
+	<-done
+
+	if members := srv.subset.Load(); members == nil || len(*members) != 4 {
+		t.Fatal("the subset isn't selected")
+	}
+	srv.Configure(WithSubset(0))
+	if srv.subset.Load() != nil {
+		t.Fatal("the subset is kept once disabled")
+	}
+}